@@ -3,8 +3,11 @@ module github.com/c0deaddict/waybar-widgets
 go 1.18
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/coreos/go-systemd v0.0.0-20191104093116-d3cd4ed1dbcf
+	github.com/fsnotify/fsnotify v1.6.0
 	github.com/go-ping/ping v1.1.0
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/joshuarubin/go-sway v1.2.0
 	github.com/pkg/errors v0.9.1
 	github.com/rs/zerolog v1.29.1
@@ -23,5 +26,5 @@ require (
 	go.uber.org/multierr v1.1.0 // indirect
 	golang.org/x/net v0.0.0-20210316092652-d523dce5a7f4 // indirect
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
-	golang.org/x/sys v0.0.0-20210927094055-39ccf1dd6fa6 // indirect
+	golang.org/x/sys v0.0.0-20220908164124-27713097b956 // indirect
 )
@@ -2,49 +2,213 @@ package pomo
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli/v2"
 )
 
 type pomoClient struct {
-	conn net.Conn
+	conn   net.Conn
+	reader *bufio.Reader
 }
 
 func newClient(c *cli.Context) (*pomoClient, error) {
-	socketPath := os.ExpandEnv(c.String("socket"))
+	socketPath := expandPath(c.String("socket"))
 	conn, err := net.Dial("unix", socketPath)
 	if err != nil {
 		return nil, fmt.Errorf("connect to server: %v", err)
 	}
 
-	return &pomoClient{conn}, nil
+	return &pomoClient{conn, bufio.NewReader(conn)}, nil
 }
 
-func sendCommand(c *cli.Context, command string) error {
+// sendCommand sends a command to the server. Commands without arguments
+// get the timer selected on the command line.
+func sendCommand(c *cli.Context, command string, args interface{}) error {
 	client, err := newClient(c)
 	if err != nil {
 		return err
 	}
-	err = client.send(command)
+	if args == nil {
+		args = timerArgs{c.String("timer")}
+	}
+	_, err = client.request(command, args)
 	client.close()
 	return err
 }
 
+const (
+	minReconnectDelay = 1 * time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// widgetClient streams the updates of the server to stdout. When the server
+// is unreachable it shows a disconnected widget and keeps reconnecting,
+// with an increasing delay. In standalone mode the server is started in
+// this process instead, if none is running, and shut down on SIGINT or
+// SIGTERM.
 func widgetClient(c *cli.Context) error {
-	client, err := newClient(c)
+	args := registerArgs{
+		timerArgs:     timerArgs{c.String("timer")},
+		Format:        c.String("format"),
+		TooltipFormat: c.String("tooltip-format"),
+		Countdown:     c.Bool("countdown"),
+	}
+
+	ctx, stop := signal.NotifyContext(c.Context, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	delay := minReconnectDelay
+	disconnected := false
+	// Closed when the standalone server stops, nil if none was started.
+	var standalone <-chan struct{}
+	for {
+		if ctx.Err() != nil {
+			// Let the standalone server save its state and remove the
+			// socket.
+			if standalone != nil {
+				<-standalone
+			}
+			return nil
+		}
+
+		err := func() error {
+			client, err := newClient(c)
+			if err != nil && c.Bool("standalone") && !running(standalone) {
+				standalone, err = startStandalone(ctx, c)
+				if err != nil {
+					return fmt.Errorf("start standalone server: %v", err)
+				}
+				client, err = newClient(c)
+			}
+			if err != nil {
+				return err
+			}
+			defer client.close()
+			// Stop streaming on a signal. The standalone server closes the
+			// connection itself when it stops.
+			done := make(chan struct{})
+			defer close(done)
+			server := standalone
+			go func() {
+				select {
+				case <-ctx.Done():
+					if !running(server) {
+						client.close()
+					}
+				case <-done:
+				}
+			}()
+			if _, err := client.request("register", args); err != nil {
+				return fmt.Errorf("register: %v", err)
+			}
+			delay = minReconnectDelay
+			disconnected = false
+			stopped, err := client.stream()
+			// Keep showing that the server stopped while reconnecting.
+			disconnected = stopped
+			return err
+		}()
+		if ctx.Err() != nil {
+			continue
+		}
+		if err != nil {
+			log.Warn().Err(err).Msg("widget")
+		}
+
+		if !disconnected {
+			disconnected = true
+			if err := disconnectedMessage.Emit(); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// startStandalone runs the server in this process, so the widget works
+// without a server running. Other commands connect to its socket as usual.
+// The returned channel is closed when the server stops.
+func startStandalone(ctx context.Context, c *cli.Context) (<-chan struct{}, error) {
+	s, err := newServer(c)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	log.Info().Msg("running standalone server")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := s.run(ctx); err != nil {
+			log.Error().Err(err).Msg("standalone server")
+		}
+	}()
+	return done, nil
+}
+
+// running returns whether the server with the given done channel is still
+// running.
+func running(done <-chan struct{}) bool {
+	if done == nil {
+		return false
+	}
+	select {
+	case <-done:
+		return false
+	default:
+		return true
+	}
+}
+
+// request sends a command and waits for the response, returning its data.
+func (c *pomoClient) request(command string, args interface{}) (json.RawMessage, error) {
+	req := request{Version: protocolVersion, Command: command}
+	if args != nil {
+		var err error
+		req.Args, err = json.Marshal(args)
+		if err != nil {
+			return nil, fmt.Errorf("marshal args: %v", err)
+		}
 	}
-	err = client.send("register")
+
+	data, err := json.Marshal(req)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("marshal request: %v", err)
+	}
+	if err := c.send(string(data)); err != nil {
+		return nil, fmt.Errorf("send request: %v", err)
+	}
+
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("read response: %v", err)
+	}
+	var resp response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("parse response: %v", err)
+	}
+	if !resp.OK {
+		return nil, errors.New(resp.Error)
 	}
-	client.stream()
-	return nil
+	return resp.Data, nil
 }
 
 func (c *pomoClient) send(command string) error {
@@ -52,18 +216,28 @@ func (c *pomoClient) send(command string) error {
 	return err
 }
 
-func (c *pomoClient) stream() {
-	reader := bufio.NewReader(c.conn)
+// stream copies the updates to stdout until the server disconnects.
+// Returns whether the last update said the server stopped.
+func (c *pomoClient) stream() (bool, error) {
+	stopped, err := json.Marshal(stoppedMessage)
+	if err != nil {
+		return false, fmt.Errorf("marshal stopped message: %v", err)
+	}
+	last := ""
 	for {
-		line, err := reader.ReadString('\n')
+		line, err := c.reader.ReadString('\n')
 		if err != nil {
+			wasStopped := strings.TrimSpace(last) == string(stopped)
 			if err != io.EOF {
-				fmt.Printf("client got disconnected: %v", err)
+				return wasStopped, fmt.Errorf("client got disconnected: %v", err)
 			}
-			break
+			return wasStopped, nil
 		}
 
-		os.Stdout.Write([]byte(line))
+		if _, err := os.Stdout.Write([]byte(line)); err != nil {
+			return false, err
+		}
+		last = line
 	}
 }
 
@@ -0,0 +1,75 @@
+package pomo
+
+import (
+	"net"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Number of messages that can be queued for a client, before it is
+	// considered too slow and dropped.
+	clientQueueSize    = 16
+	clientWriteTimeout = 5 * time.Second
+)
+
+// registeredClient is a widget that receives updates. Messages are written
+// by a goroutine per client, so a client that stopped reading can never
+// block the server.
+type registeredClient struct {
+	conn   net.Conn
+	format *widgetFormat
+	timer  *pomoTimer
+	queue  chan []byte
+	// Closed when the write loop is done.
+	done chan struct{}
+	// Phase of the last update sent to the client.
+	state string
+}
+
+func newRegisteredClient(conn net.Conn, format *widgetFormat, timer *pomoTimer) *registeredClient {
+	c := &registeredClient{
+		conn:   conn,
+		format: format,
+		timer:  timer,
+		queue:  make(chan []byte, clientQueueSize),
+		done:   make(chan struct{}),
+	}
+	go c.writeLoop()
+	return c
+}
+
+// send queues a message without blocking. Returns false if the queue is
+// full.
+func (c *registeredClient) send(message []byte) bool {
+	select {
+	case c.queue <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *registeredClient) writeLoop() {
+	defer close(c.done)
+	for message := range c.queue {
+		c.conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+		if _, err := c.conn.Write(message); err != nil {
+			log.Error().Err(err).Msgf("write to client %p failed", c.conn)
+			// Closing the connection makes the client loop remove the
+			// client, which closes the queue.
+			c.conn.Close()
+			for range c.queue {
+			}
+			return
+		}
+	}
+}
+
+// Needs s.mu locked.
+func (s *pomoServer) dropClient(i int) {
+	c := s.clients[i]
+	s.clients = append(s.clients[:i], s.clients[i+1:]...)
+	close(c.queue)
+}
@@ -0,0 +1,90 @@
+package pomo
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+// sendUpdatesN calls sendUpdates n times, failing the test if that blocks.
+func sendUpdatesN(t *testing.T, s *pomoServer, n int) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < n; i++ {
+			s.mu.Lock()
+			s.sendUpdates()
+			s.mu.Unlock()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sendUpdates blocked")
+	}
+}
+
+func TestHungClientIsDropped(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	// Nothing ever reads from the other end of the pipe, so writes to it
+	// block.
+	hung, peer := net.Pipe()
+	defer peer.Close()
+	defer hung.Close()
+
+	reader, readerPeer := net.Pipe()
+	defer readerPeer.Close()
+	defer reader.Close()
+	received := make(chan struct{}, 2*clientQueueSize)
+	go func() {
+		r := bufio.NewReader(readerPeer)
+		for {
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+			received <- struct{}{}
+		}
+	}()
+
+	s.mu.Lock()
+	s.clients = append(s.clients,
+		newRegisteredClient(hung, s.format, s.timers[0]),
+		newRegisteredClient(reader, s.format, s.timers[0]))
+	s.mu.Unlock()
+
+	// The update interval is a second, so every call sends an update. One
+	// message is being written and the queue holds clientQueueSize more,
+	// the next one overflows. Like the ticker, wait for the reading client
+	// to keep up.
+	for i := 0; i < clientQueueSize+2; i++ {
+		sendUpdatesN(t, s, 1)
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatalf("reading client got %d of %d updates", i, clientQueueSize+2)
+		}
+	}
+
+	s.mu.Lock()
+	clients := s.clients
+	s.mu.Unlock()
+	if len(clients) != 1 || clients[0].conn != reader {
+		t.Fatalf("expected only the reading client to remain, got %d clients", len(clients))
+	}
+
+	// The lock is not held while writing, so commands still get it.
+	locked := make(chan struct{})
+	go func() {
+		s.mu.Lock()
+		s.mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("s.mu is still held")
+	}
+}
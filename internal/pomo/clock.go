@@ -0,0 +1,25 @@
+package pomo
+
+import "time"
+
+// clock is the source of time for the timer logic, so it can be replaced
+// in tests.
+type clock interface {
+	now() time.Time
+	// ticker returns a channel that ticks every d, and a function to stop
+	// it.
+	ticker(d time.Duration) (<-chan time.Time, func())
+}
+
+type realClock struct{}
+
+func (realClock) now() time.Time {
+	// Strip the monotonic clock reading, it stops while the system is
+	// suspended and durations spanning a suspend would miss that time.
+	return time.Now().Round(0)
+}
+
+func (realClock) ticker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
@@ -0,0 +1,42 @@
+package pomo
+
+import (
+	"sync"
+	"time"
+)
+
+// fakeClock is a clock that only moves when told to. Its ticker fires when
+// the test sends on tick.
+type fakeClock struct {
+	mu      sync.Mutex
+	t       time.Time
+	tick    chan time.Time
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		t:    time.Date(2026, time.March, 2, 9, 0, 0, 0, time.Local),
+		tick: make(chan time.Time),
+	}
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func (c *fakeClock) ticker(d time.Duration) (<-chan time.Time, func()) {
+	return c.tick, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.stopped = true
+	}
+}
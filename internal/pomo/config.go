@@ -0,0 +1,427 @@
+package pomo
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+// setting parses the values of a setting, returning a function that applies
+// them. Timer settings apply to the given timer, the others to the whole
+// server.
+type setting func(values []string) (func(s *pomoServer, t *pomoTimer), error)
+
+// Settings that can be changed at runtime, by the name of their flag. They
+// can be changed in the config file and with pomo set, the other flags in
+// the config file only apply when the server starts.
+var settings = map[string]setting{
+	"work-time":                durationSetting(func(t *pomoTimer) *time.Duration { return &t.workTime }),
+	"break-time":               durationSetting(func(t *pomoTimer) *time.Duration { return &t.breakTime }),
+	"long-break-time":          durationSetting(func(t *pomoTimer) *time.Duration { return &t.longBreakTime }),
+	"cycles-before-long-break": uintSetting(func(t *pomoTimer) *uint { return &t.cyclesBeforeLongBreak }),
+	"overtime-interval":        durationSetting(func(t *pomoTimer) *time.Duration { return &t.overtimeInterval }),
+	"overtime-notifications":   uintSetting(func(t *pomoTimer) *uint { return &t.overtimeNotifications }),
+	"overtime-final-stage":     uintSetting(func(t *pomoTimer) *uint { return &t.overtimeFinalStage }),
+	"daily-goal":               uintSetting(func(t *pomoTimer) *uint { return &t.dailyGoal }),
+	"overtime-escalation": func(values []string) (func(s *pomoServer, t *pomoTimer), error) {
+		v, err := strconv.ParseFloat(singleValue(values), 64)
+		if err != nil {
+			return nil, err
+		}
+		if v <= 0 {
+			return nil, errors.New("overtime escalation must be positive")
+		}
+		return func(s *pomoServer, t *pomoTimer) { t.overtimeEscalation = v }, nil
+	},
+	"overtime-forever": func(values []string) (func(s *pomoServer, t *pomoTimer), error) {
+		v, err := strconv.ParseBool(singleValue(values))
+		if err != nil {
+			return nil, err
+		}
+		return func(s *pomoServer, t *pomoTimer) { t.overtimeForever = v }, nil
+	},
+	"overtime-messages": func(values []string) (func(s *pomoServer, t *pomoTimer), error) {
+		return func(s *pomoServer, t *pomoTimer) { t.overtimeMessages = values }, nil
+	},
+	"update-interval": func(values []string) (func(s *pomoServer, t *pomoTimer), error) {
+		d, err := time.ParseDuration(singleValue(values))
+		if err != nil {
+			return nil, err
+		}
+		if d < time.Second {
+			return nil, errors.New("update interval must be at least a second")
+		}
+		return func(s *pomoServer, t *pomoTimer) { s.updateInterval = d }, nil
+	},
+	// The notifier and schedule are built from these by apply.
+	"notifier": func(values []string) (func(s *pomoServer, t *pomoTimer), error) {
+		kind := singleValue(values)
+		return func(s *pomoServer, t *pomoTimer) { s.notifierKind = kind }, nil
+	},
+	"notify-command": func(values []string) (func(s *pomoServer, t *pomoTimer), error) {
+		command := singleValue(values)
+		if _, err := newCommandNotifier(command); err != nil {
+			return nil, err
+		}
+		return func(s *pomoServer, t *pomoTimer) { s.notifyCommand = command }, nil
+	},
+	"working-hours": func(values []string) (func(s *pomoServer, t *pomoTimer), error) {
+		return func(s *pomoServer, t *pomoTimer) { s.workingHours = values }, nil
+	},
+	"holidays-file": func(values []string) (func(s *pomoServer, t *pomoTimer), error) {
+		filename := expandPath(singleValue(values))
+		return func(s *pomoServer, t *pomoTimer) { s.holidaysFile = filename }, nil
+	},
+}
+
+func durationSetting(field func(t *pomoTimer) *time.Duration) setting {
+	return func(values []string) (func(s *pomoServer, t *pomoTimer), error) {
+		d, err := time.ParseDuration(singleValue(values))
+		if err != nil {
+			return nil, err
+		}
+		if d < 0 {
+			return nil, errors.New("duration must not be negative")
+		}
+		return func(s *pomoServer, t *pomoTimer) { *field(t) = d }, nil
+	}
+}
+
+func uintSetting(field func(t *pomoTimer) *uint) setting {
+	return func(values []string) (func(s *pomoServer, t *pomoTimer), error) {
+		v, err := strconv.ParseUint(singleValue(values), 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *pomoServer, t *pomoTimer) { *field(t) = uint(v) }, nil
+	}
+}
+
+// singleValue returns the value of a setting that takes one, or an
+// invalid empty value otherwise.
+func singleValue(values []string) string {
+	if len(values) != 1 {
+		return ""
+	}
+	return values[0]
+}
+
+// Needs s.mu locked.
+func (s *pomoServer) set(t *pomoTimer, name string, values []string) error {
+	if _, ok := settings[name]; !ok && s.flagNames[name] {
+		return fmt.Errorf("%s can only be changed by restarting the server", name)
+	}
+	return s.apply(t, map[string][]string{name: values})
+}
+
+// apply changes the given settings, only if all of them are valid. The
+// notifier and schedule are only rebuilt when their settings changed, so
+// the dbus notifier keeps replacing its notifications. Needs s.mu locked.
+func (s *pomoServer) apply(t *pomoTimer, values map[string][]string) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	// Check in a fixed order, so errors are reported consistently.
+	sort.Strings(names)
+	applies := make([]func(s *pomoServer, t *pomoTimer), 0, len(names))
+	for _, name := range names {
+		f, ok := settings[name]
+		if !ok {
+			return fmt.Errorf("unknown setting: %s", name)
+		}
+		apply, err := f(values[name])
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+		applies = append(applies, apply)
+	}
+
+	kind, command := s.notifierKind, s.notifyCommand
+	if v, ok := values["notifier"]; ok {
+		kind = singleValue(v)
+	}
+	if v, ok := values["notify-command"]; ok {
+		command = singleValue(v)
+	}
+	var n notifier
+	if kind != s.notifierKind || command != s.notifyCommand {
+		var err error
+		if n, err = newNotifier(kind, command); err != nil {
+			return err
+		}
+	}
+
+	workingHours, holidaysFile := s.workingHours, s.holidaysFile
+	if v, ok := values["working-hours"]; ok {
+		workingHours = v
+	}
+	if v, ok := values["holidays-file"]; ok {
+		holidaysFile = expandPath(singleValue(v))
+	}
+	var sc *schedule
+	if !equalValues(workingHours, s.workingHours) || holidaysFile != s.holidaysFile {
+		var err error
+		if sc, err = parseSchedule(workingHours, holidaysFile); err != nil {
+			return err
+		}
+	}
+
+	for _, apply := range applies {
+		apply(s, t)
+	}
+	if n != nil {
+		s.notifier = n
+	}
+	if sc != nil {
+		s.schedule = sc
+	}
+	return nil
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Needs s.mu locked.
+func (s *pomoServer) setSchedule(workingHours []string, holidaysFile string) error {
+	sc, err := parseSchedule(workingHours, holidaysFile)
+	if err != nil {
+		return err
+	}
+	s.schedule, s.workingHours, s.holidaysFile = sc, workingHours, holidaysFile
+	return nil
+}
+
+// Flags the other pomo commands read too, so they cannot be set in the
+// config file.
+var clientFlags = map[string]bool{
+	"config":       true,
+	"socket":       true,
+	"timer":        true,
+	"history-file": true,
+}
+
+// serverFlags returns the flags of the pomo command and its subcommand, by
+// name.
+func serverFlags(c *cli.Context) map[string]cli.Flag {
+	flags := make(map[string]cli.Flag)
+	for _, ctx := range c.Lineage() {
+		if ctx.Command == nil {
+			continue
+		}
+		for _, f := range ctx.Command.Flags {
+			name := f.Names()[0]
+			if _, ok := flags[name]; !ok && name != "help" {
+				flags[name] = f
+			}
+		}
+	}
+	return flags
+}
+
+// flagValues returns the value of a flag as the values of a setting.
+func flagValues(c *cli.Context, f cli.Flag) []string {
+	name := f.Names()[0]
+	if _, ok := f.(*cli.StringSliceFlag); ok {
+		return c.StringSlice(name)
+	}
+	return []string{fmt.Sprint(c.Value(name))}
+}
+
+// configValues converts a TOML value to the values of a setting.
+func configValues(value interface{}) []string {
+	if list, ok := value.([]interface{}); ok {
+		values := make([]string, 0, len(list))
+		for _, v := range list {
+			values = append(values, fmt.Sprint(v))
+		}
+		return values
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+// readConfig reads the settings in the config file. A missing file is no
+// error.
+func readConfig(filename string) (map[string][]string, error) {
+	config := make(map[string][]string)
+	if filename == "" {
+		return config, nil
+	}
+	values := make(map[string]interface{})
+	if _, err := toml.DecodeFile(filename, &values); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return config, nil
+		}
+		return nil, fmt.Errorf("read config: %v", err)
+	}
+	for name, value := range values {
+		config[name] = configValues(value)
+	}
+	return config, nil
+}
+
+// checkConfig returns an error if the config has keys that are no flags of
+// the server.
+func checkConfig(config map[string][]string, flagNames map[string]bool) error {
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if clientFlags[name] {
+			return fmt.Errorf("%s cannot be set in the config file, the other pomo commands need it too", name)
+		}
+		if !flagNames[name] {
+			return fmt.Errorf("unknown setting: %s", name)
+		}
+	}
+	return nil
+}
+
+// configContext returns a context with the flags of c, taking the values of
+// the flags not given as flag or environment variable from the config. The
+// settings are left to applyConfig.
+func configContext(c *cli.Context, flags map[string]cli.Flag, config map[string][]string) (*cli.Context, error) {
+	set := flag.NewFlagSet(c.Command.Name, flag.ContinueOnError)
+	names := make([]string, 0, len(flags))
+	for name, f := range flags {
+		if err := f.Apply(set); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var values []string
+		if c.IsSet(name) {
+			values = flagValues(c, flags[name])
+		} else if _, ok := settings[name]; !ok {
+			values = config[name]
+		}
+		for _, value := range values {
+			if err := set.Set(name, value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", name, err)
+			}
+		}
+	}
+	return cli.NewContext(c.App, set, nil), nil
+}
+
+// loadConfig reads the config file and applies the settings that can be
+// changed at runtime to the main timer and the server. Settings given as
+// flags or environment variables take precedence, settings removed from the
+// file are reset to their flag value, and the current cycle is kept.
+// Nothing is changed if any setting is invalid.
+func (s *pomoServer) loadConfig() error {
+	config, err := readConfig(s.configFile)
+	if err != nil {
+		return err
+	}
+	if err := checkConfig(config, s.flagNames); err != nil {
+		return fmt.Errorf("%s: %v", s.configFile, err)
+	}
+	if err := s.applyConfig(config); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(s.startupConfig))
+	for name := range s.startupConfig {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !s.flagsSet[name] && !equalValues(config[name], s.startupConfig[name]) {
+			log.Warn().Msgf("%s changed in %s, restart the server to apply it", name, s.configFile)
+		}
+	}
+	return nil
+}
+
+// applyConfig applies the settings that can be changed at runtime.
+func (s *pomoServer) applyConfig(config map[string][]string) error {
+	values := make(map[string][]string)
+	for name := range settings {
+		if s.flagsSet[name] {
+			continue
+		}
+		if v, ok := config[name]; ok {
+			values[name] = v
+		} else {
+			values[name] = s.defaults[name]
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.timer(defaultTimer)
+	if err != nil {
+		return err
+	}
+	if err := s.apply(t, values); err != nil {
+		return fmt.Errorf("%s: %v", s.configFile, err)
+	}
+	return nil
+}
+
+// watchConfig reloads the configuration when the config file changes.
+func (s *pomoServer) watchConfig(ctx context.Context) {
+	if s.configFile == "" {
+		return
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error().Err(err).Msg("watch config")
+		return
+	}
+	defer watcher.Close()
+
+	// Watch the directory, editors often replace the file instead of
+	// writing it.
+	dir := filepath.Dir(s.configFile)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Error().Err(err).Msg("ensure config dir")
+		return
+	}
+	if err := watcher.Add(dir); err != nil {
+		log.Error().Err(err).Msg("watch config")
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-watcher.Events:
+			if filepath.Clean(event.Name) != filepath.Clean(s.configFile) ||
+				event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			if err := s.reload(); err != nil {
+				log.Error().Err(err).Msg("reload")
+			}
+		case err := <-watcher.Errors:
+			log.Error().Err(err).Msg("watch config")
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package pomo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// testFlags are the flags of a pomo command for the config tests.
+func testFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.PathFlag{Name: "config"},
+		&cli.PathFlag{Name: "socket", Value: "pomo.sock"},
+		&cli.DurationFlag{Name: "work-time", Value: 30 * time.Minute},
+		&cli.DurationFlag{Name: "break-time", Value: 5 * time.Minute},
+		&cli.DurationFlag{Name: "idle-timeout", Value: 30 * time.Second},
+		&cli.StringSliceFlag{Name: "working-hours"},
+		&cli.StringFlag{Name: "metrics-listen"},
+	}
+}
+
+// runWithFlags runs a pomo server command with the given arguments, and
+// calls f with its context.
+func runWithFlags(t *testing.T, args []string, f func(c *cli.Context)) {
+	t.Helper()
+	app := &cli.App{
+		Commands: []*cli.Command{{
+			Name:  "pomo",
+			Flags: testFlags(),
+			Subcommands: []*cli.Command{{
+				Name:  "server",
+				Flags: []cli.Flag{&cli.StringFlag{Name: "format"}},
+				Action: func(c *cli.Context) error {
+					f(c)
+					return nil
+				},
+			}},
+		}},
+	}
+	if err := app.Run(append([]string{"test", "pomo"}, append(args, "server")...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	flagNames := map[string]bool{"socket": true, "work-time": true, "idle-timeout": true}
+	tests := []struct {
+		name    string
+		config  map[string][]string
+		wantErr bool
+	}{
+		{"setting", map[string][]string{"work-time": {"25m"}}, false},
+		{"startup flag", map[string][]string{"idle-timeout": {"1m"}}, false},
+		{"client flag", map[string][]string{"socket": {"/tmp/pomo.sock"}}, true},
+		{"unknown", map[string][]string{"work-time": {"25m"}, "bogus": {"1"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkConfig(tt.config, flagNames); (err != nil) != tt.wantErr {
+				t.Errorf("checkConfig: %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigContext(t *testing.T) {
+	config := map[string][]string{
+		"idle-timeout":   {"1m"},
+		"metrics-listen": {"localhost:9101"},
+		// Settings are applied by applyConfig.
+		"work-time": {"20m"},
+	}
+	runWithFlags(t, []string{"--metrics-listen", "localhost:9999", "--working-hours", "mon 09:00-17:00"}, func(c *cli.Context) {
+		flags := serverFlags(c)
+		for _, name := range []string{"config", "work-time", "idle-timeout", "format"} {
+			if _, ok := flags[name]; !ok {
+				t.Errorf("missing flag %s", name)
+			}
+		}
+		if _, ok := flags["help"]; ok {
+			t.Error("help is no setting")
+		}
+
+		cc, err := configContext(c, flags, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := cc.Duration("idle-timeout"); d != time.Minute {
+			t.Errorf("idle timeout %v, want the config value", d)
+		}
+		if v := cc.String("metrics-listen"); v != "localhost:9999" {
+			t.Errorf("metrics listen %s, want the flag value", v)
+		}
+		if d := cc.Duration("work-time"); d != 30*time.Minute {
+			t.Errorf("work time %v, want the default", d)
+		}
+		if v := cc.StringSlice("working-hours"); len(v) != 1 || v[0] != "mon 09:00-17:00" {
+			t.Errorf("working hours %q, want the flag value", v)
+		}
+		if v := cc.Path("socket"); v != "pomo.sock" {
+			t.Errorf("socket %s, want the default", v)
+		}
+	})
+}
+
+func TestConfigContextInvalid(t *testing.T) {
+	runWithFlags(t, nil, func(c *cli.Context) {
+		config := map[string][]string{"idle-timeout": {"soon"}}
+		if _, err := configContext(c, serverFlags(c), config); err == nil {
+			t.Error("expected an error for an invalid duration")
+		}
+	})
+}
+
+// newConfigServer returns a test server with the defaults of the flags.
+func newConfigServer(t *testing.T) *pomoServer {
+	t.Helper()
+	s, _ := newTestServer(t)
+	s.configFile = filepath.Join(t.TempDir(), "pomo.toml")
+	s.notifierKind = "none"
+	s.notifyCommand = "notify-send {{.Message}}"
+	s.flagNames = make(map[string]bool)
+	s.flagsSet = make(map[string]bool)
+	s.defaults = map[string][]string{
+		"work-time":                {"25m0s"},
+		"break-time":               {"5m0s"},
+		"long-break-time":          {"15m0s"},
+		"cycles-before-long-break": {"0"},
+		"overtime-interval":        {"5m0s"},
+		"overtime-notifications":   {"3"},
+		"overtime-final-stage":     {"0"},
+		"daily-goal":               {"0"},
+		"overtime-escalation":      {"1"},
+		"overtime-forever":         {"false"},
+		"overtime-messages":        {},
+		"update-interval":          {"1s"},
+		"notifier":                 {"none"},
+		"notify-command":           {"notify-send {{.Message}}"},
+		"working-hours":            {},
+		"holidays-file":            {""},
+	}
+	for name := range s.defaults {
+		s.flagNames[name] = true
+	}
+	s.flagNames["idle-timeout"] = true
+	s.startupConfig = map[string][]string{"idle-timeout": nil}
+	return s
+}
+
+func writeConfig(t *testing.T, s *pomoServer, config string) {
+	t.Helper()
+	if err := os.WriteFile(s.configFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	s := newConfigServer(t)
+	timer := s.timers[0]
+
+	writeConfig(t, s, "work-time = \"20m\"\nbreak-time = \"10m\"\nidle-timeout = \"1m\"\n")
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if timer.workTime != 20*time.Minute || timer.breakTime != 10*time.Minute {
+		t.Errorf("work time %v, break time %v, want the config values", timer.workTime, timer.breakTime)
+	}
+
+	// An invalid setting changes nothing.
+	writeConfig(t, s, "work-time = \"40m\"\nbreak-time = \"soon\"\n")
+	if err := s.loadConfig(); err == nil {
+		t.Error("expected an error for an invalid break time")
+	}
+	if timer.workTime != 20*time.Minute {
+		t.Errorf("work time %v changed by an invalid config", timer.workTime)
+	}
+
+	// Unknown keys change nothing either.
+	writeConfig(t, s, "work-time = \"40m\"\nbogus = 1\n")
+	if err := s.loadConfig(); err == nil {
+		t.Error("expected an error for an unknown setting")
+	}
+	if timer.workTime != 20*time.Minute {
+		t.Errorf("work time %v changed by an invalid config", timer.workTime)
+	}
+
+	// Removed settings are reset to the flag value, flags take precedence.
+	s.flagsSet["break-time"] = true
+	writeConfig(t, s, "break-time = \"1m\"\n")
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if timer.workTime != 25*time.Minute || timer.breakTime != 10*time.Minute {
+		t.Errorf("work time %v, break time %v, want the default and the flag value", timer.workTime, timer.breakTime)
+	}
+
+	// A missing file is no error.
+	if err := os.Remove(s.configFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfigKeepsNotifier(t *testing.T) {
+	s := newConfigServer(t)
+	n := s.notifier
+
+	writeConfig(t, s, "work-time = \"20m\"\nnotifier = \"none\"\n")
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if s.notifier != n {
+		t.Error("notifier rebuilt without changes")
+	}
+
+	writeConfig(t, s, "notifier = \"command\"\n")
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.notifier.(*commandNotifier); !ok {
+		t.Errorf("notifier %T, want the command notifier", s.notifier)
+	}
+	n = s.notifier
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if s.notifier != n {
+		t.Error("notifier rebuilt without changes")
+	}
+
+	// An invalid notifier keeps the old one and the other settings.
+	writeConfig(t, s, "work-time = \"40m\"\nnotifier = \"carrier-pigeon\"\n")
+	if err := s.loadConfig(); err == nil {
+		t.Error("expected an error for an unknown notifier")
+	}
+	if s.notifier != n || s.notifierKind != "command" || s.timers[0].workTime == 40*time.Minute {
+		t.Error("invalid config was applied")
+	}
+}
+
+func TestSetStartupFlag(t *testing.T) {
+	s := newConfigServer(t)
+	if err := s.set(s.timers[0], "idle-timeout", []string{"1m"}); err == nil {
+		t.Error("expected an error for a flag that only applies on startup")
+	}
+	if err := s.set(s.timers[0], "bogus", []string{"1"}); err == nil {
+		t.Error("expected an error for an unknown setting")
+	}
+	if err := s.set(s.timers[0], "work-time", []string{"1m"}); err != nil || s.timers[0].workTime != time.Minute {
+		t.Errorf("set work time: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package pomo
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	"github.com/c0deaddict/waybar-widgets/pkg/waybar"
+)
+
+const (
+	defaultTextFormat    = "{{clock .Elapsed}}"
+	defaultTooltipFormat = "Cycle {{.Cycle}}{{with .CyclesBeforeLongBreak}}/{{.}}{{end}}{{with .DailyGoal}}, {{$.Today}}/{{.}} today{{end}}{{with .Task}}\n{{.}}{{end}}"
+	// Shows the remaining time, or the time beyond the limit on overtime.
+	countdownTextFormat = "{{if .Overtime}}+{{clock .Overtime}}{{else}}{{clock .Remaining}}{{end}}"
+)
+
+// Messages shown by the widget when the server stopped, or is not
+// reachable.
+var (
+	stoppedMessage = waybar.Message{
+		Class:   []string{"stopped"},
+		Text:    "--:--",
+		Tooltip: "The pomo server stopped",
+		Alt:     "stopped",
+	}
+	disconnectedMessage = waybar.Message{
+		Class:   []string{"disconnected"},
+		Text:    "--:--",
+		Tooltip: "Not connected to the pomo server",
+		Alt:     "disconnected",
+	}
+)
+
+// widgetFormat renders the text and tooltip of the widget from the status.
+type widgetFormat struct {
+	text    *template.Template
+	tooltip *template.Template
+}
+
+func parseWidgetFormat(text, tooltip string) (*widgetFormat, error) {
+	f := widgetFormat{}
+	var err error
+	f.text, err = template.New("text").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse format: %v", err)
+	}
+	f.tooltip, err = template.New("tooltip").Funcs(templateFuncs).Parse(tooltip)
+	if err != nil {
+		return nil, fmt.Errorf("parse tooltip format: %v", err)
+	}
+	return &f, nil
+}
+
+func executeTemplate(tmpl *template.Template, status pomoStatus) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, status); err != nil {
+		return "", fmt.Errorf("execute %s template: %v", tmpl.Name(), err)
+	}
+	return buf.String(), nil
+}
+
+func (f *widgetFormat) message(status pomoStatus) (waybar.Message, error) {
+	text, err := executeTemplate(f.text, status)
+	if err != nil {
+		return waybar.Message{}, err
+	}
+	tooltip, err := executeTemplate(f.tooltip, status)
+	if err != nil {
+		return waybar.Message{}, err
+	}
+	class := []string{status.Phase}
+	if status.GoalReached {
+		class = append(class, "goal-reached")
+	}
+	return waybar.Message{
+		Class:      class,
+		Text:       text,
+		Percentage: &status.Percentage,
+		Alt:        status.Phase,
+		Tooltip:    tooltip,
+	}, nil
+}
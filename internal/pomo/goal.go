@@ -0,0 +1,55 @@
+package pomo
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// countCompleted counts a cycle completed at end towards the daily goal.
+func (t *pomoTimer) countCompleted(end time.Time) {
+	day := dayKey(end.Local())
+	if day != t.completedDay {
+		t.completedDay = day
+		t.completedToday = 0
+	}
+	t.completedToday += 1
+}
+
+// today returns the number of cycles completed since local midnight.
+func (t *pomoTimer) today() uint {
+	if t.completedDay != dayKey(t.s.clock.now().Local()) {
+		return 0
+	}
+	return t.completedToday
+}
+
+func (t *pomoTimer) goalReached() bool {
+	return t.dailyGoal != 0 && t.today() >= t.dailyGoal
+}
+
+// loadDailyProgress counts the cycles completed today from the history, so
+// the progress survives restarts of the server.
+func (s *pomoServer) loadDailyProgress() {
+	if s.historyFile == "" {
+		return
+	}
+	records, err := readHistory(s.historyFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Error().Err(err).Msg("read history")
+		}
+		return
+	}
+	today := dayKey(s.clock.now().Local())
+	for _, t := range s.timers {
+		for _, record := range records {
+			if record.timerName() == t.name && record.completed() &&
+				dayKey(record.End.Local()) == today {
+				t.countCompleted(record.End)
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package pomo
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type historyRecord struct {
+	Timer    string        `json:"timer"`
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Work     time.Duration `json:"work"`
+	Break    time.Duration `json:"break"`
+	Overtime time.Duration `json:"overtime"`
+	// The work time of the cycle, including extensions.
+	WorkTime time.Duration `json:"work_time,omitempty"`
+	// How the cycle ended: "idle", "break", "skip", "restart" or "off" at
+	// the end of the working hours.
+	Reason string `json:"reason"`
+	Task   string `json:"task,omitempty"`
+}
+
+func appendHistory(filename string, record historyRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshal history record: %v", err)
+	}
+	data = append(data, '\n')
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return fmt.Errorf("ensure history dir: %v", err)
+	}
+
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("open history: %v", err)
+	}
+	defer file.Close()
+
+	_, err = file.Write(data)
+	return err
+}
+
+func readHistory(filename string) ([]historyRecord, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := make([]historyRecord, 0)
+	s := bufio.NewScanner(file)
+	line := 0
+	for s.Scan() {
+		line += 1
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var record historyRecord
+		if err := json.Unmarshal(s.Bytes(), &record); err != nil {
+			log.Warn().Err(err).Msgf("skipping %s:%d", filename, line)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, s.Err()
+}
+
+func (t *pomoTimer) finishCycle(reason string) {
+	// A running pause is neither work nor break.
+	t.unpause()
+	now := t.s.clock.now()
+	record := historyRecord{
+		Timer:    t.name,
+		Start:    t.workStart,
+		End:      now,
+		Break:    t.breakTotal,
+		WorkTime: t.workLimit(),
+		Reason:   reason,
+		Task:     t.task,
+	}
+	if t.breakStart != nil {
+		record.Work = t.breakStart.Sub(t.workStart) - t.breakTotal - t.pauseTotal
+		record.Break += now.Sub(*t.breakStart)
+	} else {
+		record.Work = now.Sub(t.workStart) - t.breakTotal - t.pauseTotal
+	}
+	// The idle start is back-dated, so it can precede the work start.
+	if record.Work < 0 {
+		record.Work = 0
+	}
+	if record.Work > t.workLimit() {
+		record.Overtime = record.Work - t.workLimit()
+	}
+
+	if t.s.historyFile != "" {
+		if err := appendHistory(t.s.historyFile, record); err != nil {
+			log.Error().Err(err).Msg("append history")
+		}
+	}
+	if record.completed() {
+		t.cycles += 1
+		t.countCompleted(now)
+	}
+
+	t.reset()
+}
+
+// completed returns whether the cycle counts as completed, rather than
+// being cut short by a restart or the end of the working hours, or ended
+// before the work time was up. Records from before the work time was
+// stored only need some work.
+func (r historyRecord) completed() bool {
+	if r.Reason == "restart" || r.Reason == "off" {
+		return false
+	}
+	if r.WorkTime > 0 {
+		return r.Work >= r.WorkTime
+	}
+	return r.Work > 0
+}
+
+// timerName returns the name of the timer the record belongs to. Records
+// from before named timers belong to the default timer.
+func (r historyRecord) timerName() string {
+	if r.Timer == "" {
+		return defaultTimer
+	}
+	return r.Timer
+}
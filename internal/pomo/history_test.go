@@ -0,0 +1,30 @@
+package pomo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordCompleted(t *testing.T) {
+	tests := []struct {
+		name   string
+		record historyRecord
+		want   bool
+	}{
+		{"work time up", historyRecord{Work: 25 * time.Minute, WorkTime: 25 * time.Minute, Reason: "break"}, true},
+		{"overtime", historyRecord{Work: 40 * time.Minute, WorkTime: 25 * time.Minute, Reason: "idle"}, true},
+		{"early break", historyRecord{Work: 10 * time.Minute, WorkTime: 25 * time.Minute, Reason: "skip"}, false},
+		{"no work", historyRecord{Reason: "idle"}, false},
+		{"old record", historyRecord{Work: 10 * time.Minute, Reason: "break"}, true},
+		{"restart", historyRecord{Work: 30 * time.Minute, WorkTime: 25 * time.Minute, Reason: "restart"}, false},
+		{"off", historyRecord{Work: 30 * time.Minute, WorkTime: 25 * time.Minute, Reason: "off"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.record.completed(); got != tt.want {
+				t.Errorf("completed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package pomo
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+// Hook events, each with a --on-<event> flag.
+var hookEvents = []string{"work-start", "break-start", "overtime", "overtime-final", "pause", "restart"}
+
+func hookFlags() []cli.Flag {
+	flags := make([]cli.Flag, 0, len(hookEvents))
+	for _, event := range hookEvents {
+		flags = append(flags, &cli.StringFlag{
+			Name:    "on-" + event,
+			Usage:   fmt.Sprintf("shell command to run on %s", event),
+			EnvVars: []string{"POMO_ON_" + envName(event)},
+		})
+	}
+	return flags
+}
+
+func envName(event string) string {
+	return strings.ToUpper(strings.ReplaceAll(event, "-", "_"))
+}
+
+func newHooks(c *cli.Context) map[string]string {
+	hooks := make(map[string]string)
+	for _, event := range hookEvents {
+		if command := c.String("on-" + event); command != "" {
+			hooks[event] = command
+		}
+	}
+	return hooks
+}
+
+func (t *pomoTimer) runHook(event string, previous string, update pomoUpdate) {
+	command, ok := t.s.hooks[event]
+	if !ok {
+		return
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"POMO_TIMER="+t.name,
+		"POMO_EVENT="+event,
+		"POMO_PHASE="+update.class,
+		"POMO_PREVIOUS_PHASE="+previous,
+		fmt.Sprintf("POMO_ELAPSED=%d", int64(update.time.Seconds())),
+		fmt.Sprintf("POMO_CYCLES=%d", t.cycles),
+	)
+	if err := cmd.Start(); err != nil {
+		log.Error().Err(err).Msgf("start %s hook", event)
+		return
+	}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Error().Err(err).Msgf("%s hook", event)
+		}
+	}()
+}
+
+func (t *pomoTimer) detectTransition(update pomoUpdate) {
+	previous := t.phase
+	t.phase = update.class
+	if previous == "" {
+		t.activePhase = update.class
+		return
+	}
+	if previous == update.class {
+		return
+	}
+
+	if update.class == "paused" {
+		t.runHook("pause", previous, update)
+		return
+	}
+
+	// Resuming continues the phase from before the pause.
+	if update.class == t.activePhase {
+		return
+	}
+	previous = t.activePhase
+	t.activePhase = update.class
+
+	switch update.class {
+	case "work":
+		t.runHook("work-start", previous, update)
+	case "break":
+		t.runHook("break-start", previous, update)
+	case "overtime":
+		t.runHook("overtime", previous, update)
+	}
+}
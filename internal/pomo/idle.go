@@ -0,0 +1,81 @@
+package pomo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/c0deaddict/waybar-widgets/pkg/wlidle"
+)
+
+// watchWaylandIdle starts and stops breaks from the idle notifications of
+// the compositor. The idle_start and idle_stop commands keep working
+// alongside, and are the fallback if the compositor does not support it.
+// When the connection fails, like when the compositor restarts, it keeps
+// reconnecting with an increasing delay.
+func (s *pomoServer) watchWaylandIdle(ctx context.Context) {
+	delay := minReconnectDelay
+	for {
+		n, err := wlidle.Dial(s.idleTimeout)
+		if errors.Is(err, wlidle.ErrUnsupported) {
+			log.Error().Err(err).Msg("wayland idle detection stopped, only idle_start and idle_stop commands are used")
+			return
+		}
+		if err == nil {
+			delay = minReconnectDelay
+			err = s.runWaylandIdle(ctx, n)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warn().Err(err).Msgf("wayland idle detection, reconnecting in %v", delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// runWaylandIdle handles the idle notifications until the connection fails
+// or ctx is done. A break started by idleness ends with the connection, as
+// the user becoming active can no longer be noticed.
+func (s *pomoServer) runWaylandIdle(ctx context.Context, n *wlidle.Notifier) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			n.Close()
+		case <-done:
+		}
+	}()
+	defer n.Close()
+
+	idle := false
+	err := n.Run(func(idleNow bool) {
+		idle = idleNow
+		var err error
+		if idle {
+			err = s.idleStart()
+		} else {
+			err = s.idleStop()
+		}
+		if err != nil {
+			log.Warn().Err(err).Msg("wayland idle")
+		}
+	})
+	if idle && ctx.Err() == nil {
+		if err := s.idleStop(); err != nil {
+			log.Warn().Err(err).Msg("wayland idle")
+		}
+	}
+	return err
+}
@@ -0,0 +1,230 @@
+package pomo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	logindService   = "org.freedesktop.login1"
+	logindPath      = dbus.ObjectPath("/org/freedesktop/login1")
+	logindManager   = "org.freedesktop.login1.Manager"
+	logindSession   = "org.freedesktop.login1.Session"
+	dbusProperties  = "org.freedesktop.DBus.Properties"
+	logindUserSelf  = dbus.ObjectPath("/org/freedesktop/login1/user/self")
+	logindUserIface = "org.freedesktop.login1.User"
+)
+
+// sessionPath finds the logind session of the user. The server usually runs
+// as a user service outside of the session, so fall back to the graphical
+// session of the user.
+func sessionPath(conn *dbus.Conn) (dbus.ObjectPath, error) {
+	manager := conn.Object(logindService, logindPath)
+	if id := os.Getenv("XDG_SESSION_ID"); id != "" {
+		var path dbus.ObjectPath
+		err := manager.Call(logindManager+".GetSession", 0, id).Store(&path)
+		if err != nil {
+			return "", fmt.Errorf("get session %s: %v", id, err)
+		}
+		return path, nil
+	}
+
+	display, err := conn.Object(logindService, logindUserSelf).GetProperty(logindUserIface + ".Display")
+	if err != nil {
+		return "", fmt.Errorf("get display session: %v", err)
+	}
+	// The Display property is a (session id, object path) struct.
+	var session struct {
+		ID   string
+		Path dbus.ObjectPath
+	}
+	if err := dbus.Store([]interface{}{display.Value()}, &session); err != nil {
+		return "", fmt.Errorf("parse display session: %v", err)
+	}
+	if session.ID == "" {
+		return "", fmt.Errorf("user has no display session")
+	}
+	return session.Path, nil
+}
+
+// errSessionChanged is returned when the display session of the user
+// changed, like after logging out and in again.
+var errSessionChanged = errors.New("display session changed")
+
+// watchLogind treats suspending, locking and the session idle hint as
+// breaks. The break starts when the event happens, so the full time away
+// is credited. As a user service can start before the session and outlive
+// it, it keeps retrying with an increasing delay, and follows the display
+// session of the user.
+func (s *pomoServer) watchLogind(ctx context.Context) {
+	delay := minReconnectDelay
+	for {
+		err := s.runLogind(ctx, func() { delay = minReconnectDelay })
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errSessionChanged) {
+			log.Info().Msg("logind display session changed")
+			continue
+		}
+		log.Warn().Err(err).Msgf("logind, retrying in %v", delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// runLogind watches the session until the connection fails, the display
+// session changes or ctx is done. It calls watching once the session is
+// found.
+func (s *pomoServer) runLogind(ctx context.Context, watching func()) error {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return fmt.Errorf("connect to system bus: %v", err)
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	var user dbus.ObjectPath
+	err = conn.Object(logindService, logindPath).
+		Call(logindManager+".GetUser", 0, uint32(os.Getuid())).Store(&user)
+	if err != nil {
+		return fmt.Errorf("get user: %v", err)
+	}
+	// Match before looking up the session, so a change in between is not
+	// missed.
+	matches := [][]dbus.MatchOption{
+		{
+			dbus.WithMatchObjectPath(user),
+			dbus.WithMatchInterface(dbusProperties),
+			dbus.WithMatchMember("PropertiesChanged"),
+		},
+		{
+			dbus.WithMatchObjectPath(logindPath),
+			dbus.WithMatchInterface(logindManager),
+			dbus.WithMatchMember("PrepareForSleep"),
+		},
+	}
+	for _, match := range matches {
+		if err := conn.AddMatchSignal(match...); err != nil {
+			return fmt.Errorf("add match signal: %v", err)
+		}
+	}
+	signals := make(chan *dbus.Signal, 16)
+	conn.Signal(signals)
+
+	session, err := sessionPath(conn)
+	if err != nil {
+		return err
+	}
+	matches = [][]dbus.MatchOption{
+		{
+			dbus.WithMatchObjectPath(session),
+			dbus.WithMatchInterface(logindSession),
+		},
+		{
+			dbus.WithMatchObjectPath(session),
+			dbus.WithMatchInterface(dbusProperties),
+			dbus.WithMatchMember("PropertiesChanged"),
+		},
+	}
+	for _, match := range matches {
+		if err := conn.AddMatchSignal(match...); err != nil {
+			return fmt.Errorf("add match signal: %v", err)
+		}
+	}
+	log.Info().Msgf("watching logind session %s", session)
+	watching()
+
+	for signal := range signals {
+		if err := s.logindSignal(signal, user, session); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("system bus connection closed")
+}
+
+// logindSignal handles a signal of logind, returning errSessionChanged when
+// the display session of the user changed.
+func (s *pomoServer) logindSignal(signal *dbus.Signal, user, session dbus.ObjectPath) error {
+	switch signal.Name {
+	case logindManager + ".PrepareForSleep":
+		var start bool
+		if err := dbus.Store(signal.Body, &start); err != nil {
+			log.Error().Err(err).Msg("parse PrepareForSleep")
+			return nil
+		}
+		s.logindAway("sleep", start)
+	case logindSession + ".Lock":
+		if signal.Path == session {
+			s.logindAway("lock", true)
+		}
+	case logindSession + ".Unlock":
+		if signal.Path == session {
+			s.logindAway("lock", false)
+		}
+	case dbusProperties + ".PropertiesChanged":
+		var iface string
+		var changed map[string]dbus.Variant
+		var invalidated []string
+		if err := dbus.Store(signal.Body, &iface, &changed, &invalidated); err != nil {
+			log.Error().Err(err).Msg("parse PropertiesChanged")
+			return nil
+		}
+		switch {
+		case signal.Path == user && iface == logindUserIface:
+			if _, ok := changed["Display"]; ok || contains(invalidated, "Display") {
+				return errSessionChanged
+			}
+		case signal.Path == session && iface == logindSession:
+			if idle, ok := changed["IdleHint"].Value().(bool); ok {
+				s.logindAway("idle hint", idle)
+			}
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *pomoServer) logindAway(reason string, away bool) {
+	var err error
+	if away {
+		err = s.startBreak(0)
+	} else {
+		err = s.idleStop()
+	}
+	if err != nil {
+		// Sleeping and locking usually go together, so one of them
+		// finds the break already started or stopped.
+		log.Debug().Err(err).Msg(reason)
+	}
+}
@@ -0,0 +1,86 @@
+package pomo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func TestLogindSignal(t *testing.T) {
+	const (
+		user    = dbus.ObjectPath("/org/freedesktop/login1/user/_1000")
+		session = dbus.ObjectPath("/org/freedesktop/login1/session/_32")
+		other   = dbus.ObjectPath("/org/freedesktop/login1/session/_7")
+	)
+	properties := func(path dbus.ObjectPath, iface string, changed map[string]dbus.Variant, invalidated ...string) *dbus.Signal {
+		if invalidated == nil {
+			invalidated = []string{}
+		}
+		return &dbus.Signal{
+			Path: path,
+			Name: dbusProperties + ".PropertiesChanged",
+			Body: []interface{}{iface, changed, invalidated},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		signal  *dbus.Signal
+		onBreak bool
+		err     error
+	}{
+		{
+			name:    "sleep",
+			signal:  &dbus.Signal{Path: logindPath, Name: logindManager + ".PrepareForSleep", Body: []interface{}{true}},
+			onBreak: true,
+		},
+		{
+			name:    "lock",
+			signal:  &dbus.Signal{Path: session, Name: logindSession + ".Lock"},
+			onBreak: true,
+		},
+		{
+			name:   "lock of another session",
+			signal: &dbus.Signal{Path: other, Name: logindSession + ".Lock"},
+		},
+		{
+			name:    "idle hint",
+			signal:  properties(session, logindSession, map[string]dbus.Variant{"IdleHint": dbus.MakeVariant(true)}),
+			onBreak: true,
+		},
+		{
+			name:   "idle hint of another session",
+			signal: properties(other, logindSession, map[string]dbus.Variant{"IdleHint": dbus.MakeVariant(true)}),
+		},
+		{
+			name: "display changed",
+			signal: properties(user, logindUserIface, map[string]dbus.Variant{
+				"Display": dbus.MakeVariant([]interface{}{"33", dbus.ObjectPath("/org/freedesktop/login1/session/_33")}),
+			}),
+			err: errSessionChanged,
+		},
+		{
+			name:   "display invalidated",
+			signal: properties(user, logindUserIface, map[string]dbus.Variant{}, "Display"),
+			err:    errSessionChanged,
+		},
+		{
+			name:   "other user property",
+			signal: properties(user, logindUserIface, map[string]dbus.Variant{"IdleHint": dbus.MakeVariant(true)}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, clock := newTestServer(t)
+			clock.advance(10 * time.Minute)
+			if err := s.logindSignal(tt.signal, user, session); err != tt.err {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if onBreak := s.timers[0].breakStart != nil; onBreak != tt.onBreak {
+				t.Errorf("on break %v, want %v", onBreak, tt.onBreak)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package pomo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var metricPhases = []string{"work", "break", "overtime", "paused", "off"}
+
+const metricsShutdownTimeout = 5 * time.Second
+
+// writeMetrics writes the metrics of all timers in the Prometheus text
+// format. Needs s.mu locked.
+func (s *pomoServer) writeMetrics(w *bytes.Buffer) {
+	metric := func(name, kind, help string, value func(t *pomoTimer, st pomoStatus) float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, t := range s.timers {
+			st := t.statusOf(t.currentUpdate())
+			fmt.Fprintf(w, "%s{timer=%q} %g\n", name, t.name, value(t, st))
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP pomo_phase Current phase of the timer.\n# TYPE pomo_phase gauge\n")
+	for _, t := range s.timers {
+		phase := t.currentUpdate().class
+		for _, p := range metricPhases {
+			value := 0
+			if p == phase {
+				value = 1
+			}
+			fmt.Fprintf(w, "pomo_phase{timer=%q,phase=%q} %d\n", t.name, p, value)
+		}
+	}
+	metric("pomo_elapsed_seconds", "gauge", "Time spent in the current phase.",
+		func(t *pomoTimer, st pomoStatus) float64 { return st.Elapsed.Seconds() })
+	metric("pomo_overtime_seconds", "gauge", "Time worked beyond the work time in the current cycle.",
+		func(t *pomoTimer, st pomoStatus) float64 { return st.Overtime.Seconds() })
+	metric("pomo_cycles_completed", "gauge", "Completed cycles.",
+		func(t *pomoTimer, st pomoStatus) float64 { return float64(st.Cycles) })
+	metric("pomo_cycles_completed_today", "gauge", "Cycles completed since local midnight.",
+		func(t *pomoTimer, st pomoStatus) float64 { return float64(st.Today) })
+	metric("pomo_idle_breaks_total", "counter", "Breaks started by idleness.",
+		func(t *pomoTimer, st pomoStatus) float64 { return float64(t.idleBreaks) })
+	metric("pomo_restarts_total", "counter", "Restarted cycles.",
+		func(t *pomoTimer, st pomoStatus) float64 { return float64(t.restarts) })
+}
+
+// serveMetrics serves the metrics over HTTP until ctx is done.
+func (s *pomoServer) serveMetrics(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		s.mu.Lock()
+		s.writeMetrics(&buf)
+		s.mu.Unlock()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+
+	server := &http.Server{Addr: s.metricsListen, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Msg("shutdown metrics")
+		}
+	}()
+
+	log.Info().Msgf("serving metrics on %s", s.metricsListen)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error().Err(err).Msg("metrics")
+	}
+}
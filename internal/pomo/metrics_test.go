@@ -0,0 +1,54 @@
+package pomo
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeMetricsShutdown(t *testing.T) {
+	s, _ := newTestServer(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.metricsListen = l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		s.serveMetrics(ctx)
+		close(done)
+	}()
+
+	var body string
+	for i := 0; ; i++ {
+		resp, err := http.Get("http://" + s.metricsListen + "/metrics")
+		if err == nil {
+			data, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			body = string(data)
+			break
+		}
+		if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(body, `pomo_phase{timer="pomo",phase="work"} 1`) {
+		t.Errorf("unexpected metrics:\n%s", body)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("metrics server did not stop")
+	}
+}
@@ -0,0 +1,250 @@
+package pomo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/rs/zerolog/log"
+)
+
+type notification struct {
+	// Notifications with the same non-empty tag replace each other, if the
+	// backend supports it.
+	tag      string
+	message  string
+	critical bool
+}
+
+type notifier interface {
+	notify(n notification) error
+}
+
+func newNotifier(kind string, command string) (notifier, error) {
+	switch kind {
+	case "dbus":
+		return newDbusNotifier()
+	case "command":
+		return newCommandNotifier(command)
+	case "none":
+		return noopNotifier{}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier: %s", kind)
+	}
+}
+
+type noopNotifier struct{}
+
+func (noopNotifier) notify(n notification) error {
+	return nil
+}
+
+// dbusNotifier talks to org.freedesktop.Notifications directly.
+type dbusNotifier struct {
+	mu  sync.Mutex
+	obj dbus.BusObject
+	ids map[string]uint32
+}
+
+func newDbusNotifier() (*dbusNotifier, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("connect to session bus: %v", err)
+	}
+	return &dbusNotifier{
+		obj: conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications"),
+		ids: make(map[string]uint32),
+	}, nil
+}
+
+func (d *dbusNotifier) notify(n notification) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	urgency := byte(1)
+	if n.critical {
+		urgency = 2
+	}
+	hints := map[string]dbus.Variant{
+		"urgency": dbus.MakeVariant(urgency),
+	}
+
+	var replaces uint32
+	if n.tag != "" {
+		replaces = d.ids[n.tag]
+	}
+
+	var id uint32
+	err := d.obj.Call("org.freedesktop.Notifications.Notify", 0,
+		"pomo", replaces, "", n.message, "", []string{}, hints, int32(-1)).Store(&id)
+	if err != nil {
+		return fmt.Errorf("dbus notify: %v", err)
+	}
+
+	if n.tag != "" {
+		d.ids[n.tag] = id
+	}
+	return nil
+}
+
+// commandNotifier runs a command for every notification. Each argument of
+// the command is a template, which is executed with the notification. The
+// command is split on spaces outside of template actions, blocks like
+// {{if}}...{{end}} and quotes. Arguments with actions that expand to nothing
+// are left out.
+type commandNotifier struct {
+	args []*template.Template
+	// Whether the argument contains an action.
+	actions []bool
+}
+
+type commandNotification struct {
+	Message  string
+	Urgency  string
+	Critical bool
+	Tag      string
+}
+
+func newCommandNotifier(command string) (*commandNotifier, error) {
+	fields, err := splitCommand(command)
+	if err != nil {
+		return nil, fmt.Errorf("parse notify command: %v", err)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty notify command")
+	}
+	n := commandNotifier{}
+	for i, field := range fields {
+		tmpl, err := template.New(fmt.Sprintf("arg%d", i)).Parse(field)
+		if err != nil {
+			return nil, fmt.Errorf("parse notify command: %v", err)
+		}
+		n.args = append(n.args, tmpl)
+		n.actions = append(n.actions, strings.Contains(field, "{{"))
+	}
+	return &n, nil
+}
+
+// splitCommand splits a command into its arguments. Arguments are
+// separated by whitespace, except inside template actions and blocks, and
+// single or double quotes, which are removed.
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	// Nesting of blocks like {{if}}, which end with {{end}}.
+	depth := 0
+	for i := 0; i < len(command); {
+		switch c := command[i]; {
+		case strings.HasPrefix(command[i:], "{{"):
+			end := strings.Index(command[i:], "}}")
+			if end < 0 {
+				return nil, errors.New("unterminated template action")
+			}
+			action := command[i : i+end+2]
+			switch actionKeyword(action) {
+			case "if", "range", "with", "block", "define":
+				depth += 1
+			case "end":
+				depth -= 1
+			}
+			arg.WriteString(action)
+			i += end + 2
+			inArg = true
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(command[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote %c", c)
+			}
+			arg.WriteString(command[i+1 : i+1+end])
+			i += end + 2
+			inArg = true
+		case (c == ' ' || c == '\t' || c == '\n') && depth > 0:
+			arg.WriteByte(c)
+			i += 1
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+			i += 1
+		default:
+			arg.WriteByte(c)
+			i += 1
+			inArg = true
+		}
+	}
+	if depth != 0 {
+		return nil, errors.New("unterminated template block")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// actionKeyword returns the first word of a template action.
+func actionKeyword(action string) string {
+	action = strings.TrimPrefix(strings.TrimSuffix(action, "}}"), "{{")
+	action = strings.TrimPrefix(strings.TrimSuffix(action, "-"), "-")
+	fields := strings.Fields(action)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// command returns the arguments of the command for a notification.
+func (c *commandNotifier) command(n notification) ([]string, error) {
+	data := commandNotification{
+		Message:  n.message,
+		Urgency:  "normal",
+		Critical: n.critical,
+		Tag:      n.tag,
+	}
+	if n.critical {
+		data.Urgency = "critical"
+	}
+
+	args := make([]string, 0, len(c.args))
+	for i, tmpl := range c.args {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("execute notify command: %v", err)
+		}
+		if buf.Len() == 0 && c.actions[i] {
+			continue
+		}
+		args = append(args, buf.String())
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty notify command")
+	}
+	return args, nil
+}
+
+func (c *commandNotifier) notify(n notification) error {
+	args, err := c.command(n)
+	if err != nil {
+		return err
+	}
+
+	// The command may block, like notify-send -w, so it is not waited
+	// for.
+	cmd := exec.Command(args[0], args[1:]...)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("run %s: %v", args[0], err)
+	}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Error().Err(err).Msgf("notify command %s", args[0])
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,92 @@
+package pomo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+		wantErr bool
+	}{
+		{command: "notify-send  -u\tlow", want: []string{"notify-send", "-u", "low"}},
+		{command: "echo {{ .Message }}", want: []string{"echo", "{{ .Message }}"}},
+		{command: "echo pre{{ .Tag }}post x", want: []string{"echo", "pre{{ .Tag }}post", "x"}},
+		{command: `echo 'a b' "c {{.Message}} d" ''`, want: []string{"echo", "a b", "c {{.Message}} d", ""}},
+		{command: "echo {{if .Critical}}-u critical{{end}} x", want: []string{"echo", "{{if .Critical}}-u critical{{end}}", "x"}},
+		{command: "", want: nil},
+		{command: "echo {{ .Message", wantErr: true},
+		{command: "echo 'a b", wantErr: true},
+		{command: "echo {{if .Critical}}x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			got, err := splitCommand(tt.command)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandNotifier(t *testing.T) {
+	tests := []struct {
+		name         string
+		command      string
+		notification notification
+		want         []string
+	}{
+		{
+			name:         "default",
+			command:      "notify-send -a pomo -u {{.Urgency}} {{.Message}}",
+			notification: notification{message: "Take a break now"},
+			want:         []string{"notify-send", "-a", "pomo", "-u", "normal", "Take a break now"},
+		},
+		{
+			name:         "default critical",
+			command:      "notify-send -a pomo -u {{.Urgency}} {{.Message}}",
+			notification: notification{message: "Break!", critical: true},
+			want:         []string{"notify-send", "-a", "pomo", "-u", "critical", "Break!"},
+		},
+		{
+			name:         "spaced actions",
+			command:      `notify-send {{if .Critical}}--urgency=critical{{end}} -h "string:x-tag:{{ .Tag }}" {{ .Message }}`,
+			notification: notification{tag: "overtime", message: "Overtime", critical: true},
+			want:         []string{"notify-send", "--urgency=critical", "-h", "string:x-tag:overtime", "Overtime"},
+		},
+		{
+			name:         "empty action",
+			command:      `notify-send {{if .Critical}}--urgency=critical{{end}} {{ .Message }}`,
+			notification: notification{message: "Take a break now"},
+			want:         []string{"notify-send", "Take a break now"},
+		},
+		{
+			name:         "literal spaces",
+			command:      `my-notify 'pomo timer' {{.Message}}`,
+			notification: notification{message: "Back to work"},
+			want:         []string{"my-notify", "pomo timer", "Back to work"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := newCommandNotifier(tt.command)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := n.command(tt.notification)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
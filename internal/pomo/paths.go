@@ -0,0 +1,23 @@
+package pomo
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// expandPath expands environment variables in path, falling back to the
+// XDG base directory defaults when those are not set.
+func expandPath(path string) string {
+	return os.Expand(path, func(key string) string {
+		if value := os.Getenv(key); value != "" {
+			return value
+		}
+		switch key {
+		case "XDG_STATE_HOME":
+			return filepath.Join(os.Getenv("HOME"), ".local", "state")
+		case "XDG_CONFIG_HOME":
+			return filepath.Join(os.Getenv("HOME"), ".config")
+		}
+		return ""
+	})
+}
@@ -1,6 +1,9 @@
 package pomo
 
 import (
+	"fmt"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/urfave/cli/v2"
@@ -10,12 +13,28 @@ func PomoCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "pomo",
 		Usage: "pomodoro timer",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
+			&cli.PathFlag{
+				Name:    "config",
+				Usage:   "toml file with settings, which are reloaded when it changes",
+				Value:   "$XDG_CONFIG_HOME/waybar-widgets/pomo.toml",
+				EnvVars: []string{"POMO_CONFIG"},
+			},
 			&cli.PathFlag{
 				Name:    "socket",
 				Value:   "$XDG_RUNTIME_DIR/waybar-widgets/pomo.sock",
 				EnvVars: []string{"POMO_SOCKET"},
 			},
+			&cli.StringFlag{
+				Name:    "timer",
+				Usage:   "name of the timer to control, defaults to the main timer (all timers for idle commands)",
+				EnvVars: []string{"POMO_TIMER"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "timers",
+				Usage:   "additional timers as name:work:break[:long-break:cycles]",
+				EnvVars: []string{"POMO_TIMERS"},
+			},
 			&cli.DurationFlag{
 				Name:    "work-time",
 				Value:   30 * time.Minute,
@@ -26,6 +45,31 @@ func PomoCommand() *cli.Command {
 				Value:   5 * time.Minute,
 				EnvVars: []string{"POMO_BREAK_TIME"},
 			},
+			&cli.DurationFlag{
+				Name:    "long-break-time",
+				Value:   15 * time.Minute,
+				EnvVars: []string{"POMO_LONG_BREAK_TIME"},
+			},
+			&cli.UintFlag{
+				Name:    "cycles-before-long-break",
+				Usage:   "take a long break every this many cycles, 0 to disable",
+				EnvVars: []string{"POMO_CYCLES_BEFORE_LONG_BREAK"},
+			},
+			&cli.UintFlag{
+				Name:    "daily-goal",
+				Usage:   "number of cycles to complete per day, 0 to disable",
+				EnvVars: []string{"POMO_DAILY_GOAL"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "working-hours",
+				Usage:   "days and time ranges to work, like \"mon-fri 09:00-12:00,13:00-17:00\", always if empty",
+				EnvVars: []string{"POMO_WORKING_HOURS"},
+			},
+			&cli.PathFlag{
+				Name:    "holidays-file",
+				Usage:   "file with a YYYY-MM-DD date per line to not work on",
+				EnvVars: []string{"POMO_HOLIDAYS_FILE"},
+			},
 			&cli.DurationFlag{
 				Name:    "update-interval",
 				Value:   5 * time.Second,
@@ -36,6 +80,16 @@ func PomoCommand() *cli.Command {
 				Value:   30 * time.Second,
 				EnvVars: []string{"POMO_IDLE_TIMEOUT"},
 			},
+			&cli.BoolFlag{
+				Name:    "wayland-idle",
+				Usage:   "detect idleness with the ext-idle-notify-v1 wayland protocol",
+				EnvVars: []string{"POMO_WAYLAND_IDLE"},
+			},
+			&cli.BoolFlag{
+				Name:    "logind",
+				Usage:   "take a break while the system sleeps or the session is locked or idle",
+				EnvVars: []string{"POMO_LOGIND"},
+			},
 			&cli.DurationFlag{
 				Name:    "overtime-interval",
 				Value:   5 * time.Minute,
@@ -46,22 +100,113 @@ func PomoCommand() *cli.Command {
 				Value:   3,
 				EnvVars: []string{"POMO_OVERTIME_NOTIFICATIONS"},
 			},
-		},
+			&cli.Float64Flag{
+				Name:    "overtime-escalation",
+				Usage:   "factor applied to the overtime interval after each notification, below 1 to nag more often",
+				Value:   1,
+				EnvVars: []string{"POMO_OVERTIME_ESCALATION"},
+			},
+			&cli.BoolFlag{
+				Name:    "overtime-forever",
+				Usage:   "keep sending overtime notifications after overtime-notifications",
+				EnvVars: []string{"POMO_OVERTIME_FOREVER"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "overtime-messages",
+				Usage:   "message per overtime notification, the last one is repeated",
+				EnvVars: []string{"POMO_OVERTIME_MESSAGES"},
+			},
+			&cli.UintFlag{
+				Name:    "overtime-final-stage",
+				Usage:   "overtime notification after which the on-overtime-final hook runs, 0 for after the last one",
+				EnvVars: []string{"POMO_OVERTIME_FINAL_STAGE"},
+			},
+			&cli.StringFlag{
+				Name:    "metrics-listen",
+				Usage:   "address to serve prometheus metrics on, like localhost:9101, empty to disable",
+				EnvVars: []string{"POMO_METRICS_LISTEN"},
+			},
+			&cli.StringFlag{
+				Name:    "notifier",
+				Usage:   "notification backend: dbus, command or none",
+				Value:   "command",
+				EnvVars: []string{"POMO_NOTIFIER"},
+			},
+			&cli.StringFlag{
+				Name:    "notify-command",
+				Usage:   "command run by the command notifier, every argument is a go template",
+				Value:   "notify-send -a pomo -u {{.Urgency}} {{.Message}}",
+				EnvVars: []string{"POMO_NOTIFY_COMMAND"},
+			},
+			&cli.PathFlag{
+				Name:    "state-file",
+				Usage:   "file to persist the server state in, empty to disable",
+				Value:   "$XDG_STATE_HOME/waybar-widgets/pomo.json",
+				EnvVars: []string{"POMO_STATE_FILE"},
+			},
+			&cli.DurationFlag{
+				Name:    "state-max-age",
+				Usage:   "discard saved state older than this on startup, 0 to always restore",
+				Value:   2 * time.Hour,
+				EnvVars: []string{"POMO_STATE_MAX_AGE"},
+			},
+			&cli.PathFlag{
+				Name:    "history-file",
+				Usage:   "file to log finished cycles to, empty to disable",
+				Value:   "$XDG_STATE_HOME/waybar-widgets/pomo-history.jsonl",
+				EnvVars: []string{"POMO_HISTORY_FILE"},
+			},
+		}, hookFlags()...),
 		Subcommands: []*cli.Command{
 			{
 				Name:  "server",
 				Usage: "background server",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "format",
+						Usage:   "go template for the widget text",
+						Value:   defaultTextFormat,
+						EnvVars: []string{"POMO_FORMAT"},
+					},
+					&cli.StringFlag{
+						Name:    "tooltip-format",
+						Usage:   "go template for the widget tooltip",
+						Value:   defaultTooltipFormat,
+						EnvVars: []string{"POMO_TOOLTIP_FORMAT"},
+					},
+				},
 				Action: func(c *cli.Context) error {
 					s, err := newServer(c)
 					if err != nil {
 						return err
 					}
-					return s.run()
+					ctx, stop := signal.NotifyContext(c.Context, syscall.SIGINT, syscall.SIGTERM)
+					defer stop()
+					return s.run(ctx)
 				},
 			},
 			{
 				Name:  "widget",
 				Usage: "widget client",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "go template for the widget text, defaults to the server format",
+					},
+					&cli.StringFlag{
+						Name:  "tooltip-format",
+						Usage: "go template for the widget tooltip, defaults to the server format",
+					},
+					&cli.BoolFlag{
+						Name:  "countdown",
+						Usage: "show the remaining time instead of the elapsed time",
+					},
+					&cli.BoolFlag{
+						Name:    "standalone",
+						Usage:   "run the server in the widget if it is not running",
+						EnvVars: []string{"POMO_STANDALONE"},
+					},
+				},
 				Action: func(c *cli.Context) error {
 					return widgetClient(c)
 				},
@@ -70,21 +215,127 @@ func PomoCommand() *cli.Command {
 				Name:  "idle_start",
 				Usage: "signal idle start",
 				Action: func(c *cli.Context) error {
-					return sendCommand(c, "idle_start")
+					return sendCommand(c, "idle_start", nil)
 				},
 			},
 			{
 				Name:  "idle_stop",
 				Usage: "signal idle stop",
 				Action: func(c *cli.Context) error {
-					return sendCommand(c, "idle_stop")
+					return sendCommand(c, "idle_stop", nil)
 				},
 			},
 			{
 				Name:  "restart",
 				Usage: "restart timer",
 				Action: func(c *cli.Context) error {
-					return sendCommand(c, "restart")
+					return sendCommand(c, "restart", nil)
+				},
+			},
+			{
+				Name:  "pause",
+				Usage: "pause timer",
+				Action: func(c *cli.Context) error {
+					return sendCommand(c, "pause", nil)
+				},
+			},
+			{
+				Name:  "resume",
+				Usage: "resume paused timer",
+				Action: func(c *cli.Context) error {
+					return sendCommand(c, "resume", nil)
+				},
+			},
+			{
+				Name:  "toggle",
+				Usage: "pause or resume timer",
+				Action: func(c *cli.Context) error {
+					return sendCommand(c, "toggle", nil)
+				},
+			},
+			{
+				Name:  "skip",
+				Usage: "start a break, or a new work cycle when on a break",
+				Action: func(c *cli.Context) error {
+					return sendCommand(c, "skip", nil)
+				},
+			},
+			{
+				Name:      "extend",
+				Usage:     "extend the current work period or break",
+				ArgsUsage: "<duration>",
+				Action: func(c *cli.Context) error {
+					d, err := time.ParseDuration(c.Args().First())
+					if err != nil {
+						return fmt.Errorf("parse duration: %v", err)
+					}
+					return sendCommand(c, "extend", extendArgs{timerArgs{c.String("timer")}, d})
+				},
+			},
+			{
+				Name:      "task",
+				Usage:     "label the current cycle with a task, clears the label without arguments",
+				ArgsUsage: "[<label>]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "list",
+						Usage: "list recent tasks from the history, most recent first",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return taskCommand(c)
+				},
+			},
+			{
+				Name:      "set",
+				Usage:     "change a setting of the running server, until it restarts",
+				ArgsUsage: "<setting> <value>...",
+				Action: func(c *cli.Context) error {
+					if c.NArg() < 1 {
+						return fmt.Errorf("missing setting")
+					}
+					args := setArgs{timerArgs{c.String("timer")}, c.Args().First(), c.Args().Tail()}
+					return sendCommand(c, "set", args)
+				},
+			},
+			{
+				Name:  "status",
+				Usage: "show the current timer status",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "format",
+						Usage:   "output format: text, json or template",
+						Value:   "text",
+						Aliases: []string{"f"},
+					},
+					&cli.StringFlag{
+						Name:    "template",
+						Usage:   "go template used by the template format",
+						Value:   "{{.Phase}} {{clock .Elapsed}}",
+						Aliases: []string{"t"},
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return statusCommand(c)
+				},
+			},
+			{
+				Name:  "stats",
+				Usage: "show daily and weekly totals from the history",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "days",
+						Usage: "number of days to show",
+						Value: 7,
+					},
+					&cli.IntFlag{
+						Name:  "weeks",
+						Usage: "number of weeks to show",
+						Value: 4,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return statsCommand(c)
 				},
 			},
 		},
@@ -0,0 +1,226 @@
+package pomo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Version of the JSON protocol spoken over the socket. Requests without a
+// version are assumed to use the current one.
+const protocolVersion = 1
+
+type request struct {
+	Version int             `json:"version"`
+	Command string          `json:"command"`
+	Args    json.RawMessage `json:"args,omitempty"`
+}
+
+type response struct {
+	Version int             `json:"version"`
+	OK      bool            `json:"ok"`
+	Error   string          `json:"error,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// timerArgs selects the timer a command applies to, the default timer if
+// empty. Idle commands apply to all timers if empty.
+type timerArgs struct {
+	Timer string `json:"timer,omitempty"`
+}
+
+type extendArgs struct {
+	timerArgs
+	Duration time.Duration `json:"duration"`
+}
+
+type setArgs struct {
+	timerArgs
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+type registerArgs struct {
+	timerArgs
+	Format        string `json:"format,omitempty"`
+	TooltipFormat string `json:"tooltip_format,omitempty"`
+	// Show the remaining time instead of the elapsed time, unless a
+	// format is given.
+	Countdown bool `json:"countdown,omitempty"`
+}
+
+type commandHandler func(s *pomoServer, args json.RawMessage) (interface{}, error)
+
+var commands = map[string]commandHandler{
+	"idle_start": func(s *pomoServer, args json.RawMessage) (interface{}, error) {
+		return nil, s.forTimers(args, func(t *pomoTimer) error {
+			return t.startBreak(s.idleTimeout)
+		})
+	},
+	"idle_stop": func(s *pomoServer, args json.RawMessage) (interface{}, error) {
+		return nil, s.forTimers(args, func(t *pomoTimer) error {
+			return t.idleStop()
+		})
+	},
+	"restart": func(s *pomoServer, args json.RawMessage) (interface{}, error) {
+		return nil, s.withTimer(args, func(t *pomoTimer) error {
+			return t.restart()
+		})
+	},
+	"pause": func(s *pomoServer, args json.RawMessage) (interface{}, error) {
+		return nil, s.withTimer(args, func(t *pomoTimer) error {
+			return t.pause()
+		})
+	},
+	"resume": func(s *pomoServer, args json.RawMessage) (interface{}, error) {
+		return nil, s.withTimer(args, func(t *pomoTimer) error {
+			return t.resume()
+		})
+	},
+	"toggle": func(s *pomoServer, args json.RawMessage) (interface{}, error) {
+		return nil, s.withTimer(args, func(t *pomoTimer) error {
+			return t.toggle()
+		})
+	},
+	"skip": func(s *pomoServer, args json.RawMessage) (interface{}, error) {
+		return nil, s.withTimer(args, func(t *pomoTimer) error {
+			return t.skip()
+		})
+	},
+	"extend": func(s *pomoServer, args json.RawMessage) (interface{}, error) {
+		var a extendArgs
+		if err := parseArgs(args, &a); err != nil {
+			return nil, err
+		}
+		return nil, s.withTimer(args, func(t *pomoTimer) error {
+			return t.extend(a.Duration)
+		})
+	},
+	"task": func(s *pomoServer, args json.RawMessage) (interface{}, error) {
+		var a taskArgs
+		if err := parseArgs(args, &a); err != nil {
+			return nil, err
+		}
+		return nil, s.withTimer(args, func(t *pomoTimer) error {
+			t.setTask(a.Task)
+			return nil
+		})
+	},
+	"set": func(s *pomoServer, args json.RawMessage) (interface{}, error) {
+		var a setArgs
+		if err := parseArgs(args, &a); err != nil {
+			return nil, err
+		}
+		return nil, s.withTimer(args, func(t *pomoTimer) error {
+			return s.set(t, a.Name, a.Values)
+		})
+	},
+	"status": func(s *pomoServer, args json.RawMessage) (interface{}, error) {
+		var status pomoStatus
+		err := s.withTimer(args, func(t *pomoTimer) error {
+			status = t.statusOf(t.currentUpdate())
+			return nil
+		})
+		return status, err
+	},
+	// Registration is completed in handleRequest, after the response is
+	// written, so the response always precedes the first update.
+	"register": func(s *pomoServer, args json.RawMessage) (interface{}, error) {
+		if _, err := s.clientFormat(args); err != nil {
+			return nil, err
+		}
+		return nil, s.withTimer(args, func(t *pomoTimer) error {
+			return nil
+		})
+	},
+}
+
+func parseArgs(args json.RawMessage, v interface{}) error {
+	if len(args) == 0 {
+		return errors.New("missing arguments")
+	}
+	if err := json.Unmarshal(args, v); err != nil {
+		return fmt.Errorf("invalid arguments: %v", err)
+	}
+	return nil
+}
+
+var errUnknownCommand = errors.New("unknown command")
+
+// Commands understood in the plain text protocol, which consists of a
+// single word per line and has no responses.
+var legacyCommands = []string{"idle_start", "idle_stop", "restart", "register"}
+
+func (s *pomoServer) handleLine(conn net.Conn, line string) {
+	if strings.HasPrefix(line, "{") {
+		s.handleRequest(conn, line)
+	} else {
+		s.handleLegacy(conn, line)
+	}
+}
+
+func (s *pomoServer) handleLegacy(conn net.Conn, command string) {
+	for _, legacy := range legacyCommands {
+		if command != legacy {
+			continue
+		}
+		if _, err := commands[command](s, nil); err != nil {
+			log.Warn().Err(err).Msg(command)
+		}
+		if command == "register" {
+			s.register(conn, nil)
+		}
+		return
+	}
+	log.Warn().Msgf("unknown command received: %s", command)
+}
+
+func (s *pomoServer) handleRequest(conn net.Conn, line string) {
+	var req request
+	data, err := func() (interface{}, error) {
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			return nil, fmt.Errorf("invalid request: %v", err)
+		}
+		if req.Version > protocolVersion {
+			return nil, fmt.Errorf("unsupported protocol version %d", req.Version)
+		}
+		handler, ok := commands[req.Command]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", errUnknownCommand, req.Command)
+		}
+		return handler(s, req.Args)
+	}()
+
+	resp := response{Version: protocolVersion, OK: err == nil}
+	if err != nil {
+		resp.Error = err.Error()
+	} else if data != nil {
+		resp.Data, err = json.Marshal(data)
+		if err != nil {
+			resp.OK = false
+			resp.Error = fmt.Sprintf("marshal data: %v", err)
+		}
+	}
+
+	message, err := json.Marshal(resp)
+	if err != nil {
+		log.Error().Err(err).Msg("marshal response")
+		return
+	}
+	conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+	if _, err := conn.Write(append(message, '\n')); err != nil {
+		log.Error().Err(err).Msg("write response")
+		return
+	}
+
+	if resp.OK && req.Command == "register" {
+		if err := s.register(conn, req.Args); err != nil {
+			log.Error().Err(err).Msg("register")
+		}
+	}
+}
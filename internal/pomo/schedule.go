@@ -0,0 +1,214 @@
+package pomo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// timeRange is a range of the day, as offsets from midnight.
+type timeRange struct {
+	start time.Duration
+	end   time.Duration
+}
+
+// schedule holds the working hours. Outside of them the timers are off.
+type schedule struct {
+	days     [7][]timeRange
+	holidays map[string]bool
+}
+
+// parseSchedule parses working hours like "mon-fri 09:00-12:00,13:00-17:00"
+// and the holidays file. Without working hours every day is a working day,
+// without either there is no schedule and nil is returned.
+func parseSchedule(entries []string, holidaysFile string) (*schedule, error) {
+	if len(entries) == 0 && holidaysFile == "" {
+		return nil, nil
+	}
+
+	sc := schedule{holidays: make(map[string]bool)}
+	if len(entries) == 0 {
+		for day := range sc.days {
+			sc.days[day] = []timeRange{{0, 24 * time.Hour}}
+		}
+	}
+	for _, entry := range joinEntries(entries) {
+		if err := sc.parseEntry(entry); err != nil {
+			return nil, fmt.Errorf("invalid working hours %s: %v", entry, err)
+		}
+	}
+
+	if holidaysFile != "" {
+		if err := sc.readHolidays(holidaysFile); err != nil {
+			return nil, err
+		}
+	}
+	return &sc, nil
+}
+
+// joinEntries joins the parts of entries that were split on commas, like the
+// working-hours flag does with "mon,wed 09:00-12:00,13:00-17:00". A part
+// without a space is a list of days when it has no time in it, and belongs
+// to the next part, or more time ranges of the previous part.
+func joinEntries(entries []string) []string {
+	joined := make([]string, 0, len(entries))
+	days := ""
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if days != "" {
+			entry = days + "," + entry
+			days = ""
+		}
+		if !strings.ContainsAny(entry, " \t") {
+			if strings.Contains(entry, ":") && len(joined) > 0 {
+				joined[len(joined)-1] += "," + entry
+			} else {
+				days = entry
+			}
+			continue
+		}
+		joined = append(joined, entry)
+	}
+	if days != "" {
+		joined = append(joined, days)
+	}
+	return joined
+}
+
+func (sc *schedule) parseEntry(entry string) error {
+	fields := strings.Fields(entry)
+	if len(fields) != 2 {
+		return fmt.Errorf("expected days and time ranges")
+	}
+	days, err := parseDays(fields[0])
+	if err != nil {
+		return err
+	}
+	for _, value := range strings.Split(fields[1], ",") {
+		r, err := parseTimeRange(value)
+		if err != nil {
+			return err
+		}
+		for _, day := range days {
+			sc.days[day] = append(sc.days[day], r)
+		}
+	}
+	return nil
+}
+
+// parseDays parses a list of days like "mon,wed" or "mon-fri".
+func parseDays(value string) ([]time.Weekday, error) {
+	days := make([]time.Weekday, 0)
+	for _, part := range strings.Split(value, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		start, ok := weekdays[strings.ToLower(first)]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %s", first)
+		}
+		end := start
+		if isRange {
+			end, ok = weekdays[strings.ToLower(last)]
+			if !ok {
+				return nil, fmt.Errorf("unknown day %s", last)
+			}
+		}
+		for day := start; ; day = (day + 1) % 7 {
+			days = append(days, day)
+			if day == end {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseTimeRange(value string) (timeRange, error) {
+	first, last, ok := strings.Cut(value, "-")
+	if !ok {
+		return timeRange{}, fmt.Errorf("invalid time range %s", value)
+	}
+	start, err := parseTimeOfDay(first)
+	if err != nil {
+		return timeRange{}, err
+	}
+	end, err := parseTimeOfDay(last)
+	if err != nil {
+		return timeRange{}, err
+	}
+	if end <= start {
+		return timeRange{}, fmt.Errorf("time range %s ends before it starts", value)
+	}
+	return timeRange{start, end}, nil
+}
+
+// parseTimeOfDay parses HH:MM as the offset from midnight, allowing 24:00
+// for the end of the day.
+func parseTimeOfDay(value string) (time.Duration, error) {
+	var hours, minutes int
+	if _, err := fmt.Sscanf(value, "%d:%d", &hours, &minutes); err != nil {
+		return 0, fmt.Errorf("invalid time %s", value)
+	}
+	d := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute
+	if hours < 0 || minutes < 0 || minutes > 59 || d > 24*time.Hour {
+		return 0, fmt.Errorf("invalid time %s", value)
+	}
+	return d, nil
+}
+
+// readHolidays reads a file with a YYYY-MM-DD date per line. Everything
+// after the date, and lines starting with #, are ignored.
+func (sc *schedule) readHolidays(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("open holidays: %v", err)
+	}
+	defer file.Close()
+
+	s := bufio.NewScanner(file)
+	line := 0
+	for s.Scan() {
+		line += 1
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", fields[0])
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid date %s", filename, line, fields[0])
+		}
+		sc.holidays[dayKey(date)] = true
+	}
+	return s.Err()
+}
+
+// working returns whether t is within the working hours. A nil schedule is
+// always working.
+func (sc *schedule) working(t time.Time) bool {
+	if sc == nil {
+		return true
+	}
+	t = t.Local()
+	if sc.holidays[dayKey(t)] {
+		return false
+	}
+	year, month, day := t.Date()
+	offset := t.Sub(time.Date(year, month, day, 0, 0, 0, 0, time.Local))
+	for _, r := range sc.days[t.Weekday()] {
+		if offset >= r.start && offset < r.end {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package pomo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	morning := timeRange{9 * time.Hour, 12 * time.Hour}
+	afternoon := timeRange{13 * time.Hour, 17 * time.Hour}
+	tests := []struct {
+		name    string
+		entries []string
+		want    [7][]timeRange
+	}{
+		{
+			name:    "entry",
+			entries: []string{"mon-fri 09:00-12:00,13:00-17:00"},
+			want: [7][]timeRange{
+				nil,
+				{morning, afternoon},
+				{morning, afternoon},
+				{morning, afternoon},
+				{morning, afternoon},
+				{morning, afternoon},
+				nil,
+			},
+		},
+		{
+			name:    "split on commas",
+			entries: []string{"mon", "wed 09:00-12:00", "13:00-17:00", "sat 09:00-12:00"},
+			want: [7][]timeRange{
+				nil,
+				{morning, afternoon},
+				nil,
+				{morning, afternoon},
+				nil,
+				nil,
+				{morning},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := parseSchedule(tt.entries, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sc.days, tt.want) {
+				t.Errorf("got %v, want %v", sc.days, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, entries := range [][]string{
+		{"mon-fri"},
+		{"09:00-17:00"},
+		{"mon-fri 09:00"},
+		{"someday 09:00-17:00"},
+		{"mon 17:00-09:00"},
+	} {
+		if _, err := parseSchedule(entries, ""); err == nil {
+			t.Errorf("expected an error for %q", entries)
+		}
+	}
+}
@@ -2,41 +2,70 @@ package pomo
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
-	"os/exec"
-	"path"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/coreos/go-systemd/activation"
 	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli/v2"
-
-	"github.com/c0deaddict/waybar-widgets/pkg/waybar"
 )
 
 type pomoServer struct {
-	workTime              time.Duration
-	breakTime             time.Duration
-	updateInterval        time.Duration
-	idleTimeout           time.Duration
-	overtimeInterval      time.Duration
-	overtimeNotifications uint
-
-	mu           sync.Mutex
-	listener     net.Listener
-	clients      []net.Conn
-	clientStates map[net.Conn]string
-
-	workStart         time.Time
-	breakStart        *time.Time
-	breakTotal        time.Duration
-	notificationsSent map[uint]bool
+	updateInterval time.Duration
+	idleTimeout    time.Duration
+	waylandIdle    bool
+	logind         bool
+	stateFile      string
+	stateMaxAge    time.Duration
+	historyFile    string
+	notifier       notifier
+	notifierKind   string
+	notifyCommand  string
+	hooks          map[string]string
+	textFormat     string
+	tooltipFormat  string
+	format         *widgetFormat
+	schedule       *schedule
+	metricsListen  string
+	clock          clock
+
+	// Path of the socket to remove on shutdown, empty when socket
+	// activated.
+	socketPath string
+	// Lock on the socket, released after removing it.
+	socketLock   *os.File
+	workingHours []string
+	holidaysFile string
+	configFile   string
+	// Names of the flags, which are the keys of the config file.
+	flagNames map[string]bool
+	// Flags given on the command line or as environment variables, which
+	// the config file does not override.
+	flagsSet map[string]bool
+	// Flag values of the settings, which apply when they are removed from
+	// the config file.
+	defaults map[string][]string
+	// Config values of the flags that only apply on startup.
+	startupConfig map[string][]string
+
+	// Notifications are sent by a separate goroutine, so a slow notifier
+	// never blocks while s.mu is held.
+	notifications chan notification
+
+	mu       sync.Mutex
+	listener net.Listener
+	clients  []*registeredClient
+	timers   []*pomoTimer
 }
 
 type pomoUpdate struct {
@@ -46,13 +75,118 @@ type pomoUpdate struct {
 }
 
 func newServer(c *cli.Context) (*pomoServer, error) {
+	flags := serverFlags(c)
+	flagNames := make(map[string]bool)
+	flagsSet := make(map[string]bool)
+	for name := range flags {
+		flagNames[name] = true
+		if c.IsSet(name) {
+			flagsSet[name] = true
+		}
+	}
+	defaults := make(map[string][]string)
+	for name := range settings {
+		defaults[name] = flagValues(c, flags[name])
+	}
+
+	configFile := expandPath(c.Path("config"))
+	fileConfig, err := readConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkConfig(fileConfig, flagNames); err != nil {
+		return nil, fmt.Errorf("%s: %v", configFile, err)
+	}
+	startupConfig := make(map[string][]string)
+	for name := range flags {
+		if _, ok := settings[name]; !ok {
+			startupConfig[name] = fileConfig[name]
+		}
+	}
+	c, err = configContext(c, flags, fileConfig)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", configFile, err)
+	}
+
 	s := pomoServer{
+		configFile:     configFile,
+		flagNames:      flagNames,
+		flagsSet:       flagsSet,
+		defaults:       defaults,
+		startupConfig:  startupConfig,
+		updateInterval: c.Duration("update-interval"),
+		idleTimeout:    c.Duration("idle-timeout"),
+		waylandIdle:    c.Bool("wayland-idle"),
+		logind:         c.Bool("logind"),
+		stateFile:      expandPath(c.Path("state-file")),
+		stateMaxAge:    c.Duration("state-max-age"),
+		historyFile:    expandPath(c.Path("history-file")),
+		hooks:          newHooks(c),
+		textFormat:     c.String("format"),
+		tooltipFormat:  c.String("tooltip-format"),
+		metricsListen:  c.String("metrics-listen"),
+		clock:          realClock{},
+		notifications:  make(chan notification, notificationQueueSize),
+	}
+
+	// The widget flags of a standalone server default to the server
+	// formats.
+	if s.textFormat == "" {
+		s.textFormat = defaultTextFormat
+	}
+	if s.tooltipFormat == "" {
+		s.tooltipFormat = defaultTooltipFormat
+	}
+
+	config := timerConfig{
 		workTime:              c.Duration("work-time"),
 		breakTime:             c.Duration("break-time"),
-		updateInterval:        c.Duration("update-interval"),
-		idleTimeout:           c.Duration("idle-timeout"),
+		longBreakTime:         c.Duration("long-break-time"),
+		cyclesBeforeLongBreak: c.Uint("cycles-before-long-break"),
 		overtimeInterval:      c.Duration("overtime-interval"),
 		overtimeNotifications: c.Uint("overtime-notifications"),
+		overtimeEscalation:    c.Float64("overtime-escalation"),
+		overtimeForever:       c.Bool("overtime-forever"),
+		overtimeMessages:      c.StringSlice("overtime-messages"),
+		overtimeFinalStage:    c.Uint("overtime-final-stage"),
+		dailyGoal:             c.Uint("daily-goal"),
+	}
+	if config.overtimeEscalation <= 0 {
+		return nil, fmt.Errorf("overtime escalation must be positive")
+	}
+	s.timers = append(s.timers, newTimer(&s, defaultTimer, config))
+	for _, value := range c.StringSlice("timers") {
+		name, timerConfig, err := parseTimerConfig(value, config)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.timer(name); err == nil {
+			return nil, fmt.Errorf("duplicate timer %s", name)
+		}
+		s.timers = append(s.timers, newTimer(&s, name, timerConfig))
+	}
+
+	s.format, err = parseWidgetFormat(s.textFormat, s.tooltipFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	s.workingHours = c.StringSlice("working-hours")
+	s.holidaysFile = expandPath(c.Path("holidays-file"))
+	s.schedule, err = parseSchedule(s.workingHours, s.holidaysFile)
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifierKind = c.String("notifier")
+	s.notifyCommand = c.String("notify-command")
+	s.notifier, err = newNotifier(s.notifierKind, s.notifyCommand)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.applyConfig(fileConfig); err != nil {
+		return nil, err
 	}
 
 	listeners, err := activation.Listeners()
@@ -65,38 +199,62 @@ func newServer(c *cli.Context) (*pomoServer, error) {
 		log.Info().Msg("using socket activation")
 		s.listener = listeners[0]
 	} else {
-		socketPath := os.ExpandEnv(c.String("socket"))
-		err := os.MkdirAll(path.Dir(socketPath), os.ModePerm)
+		s.socketPath = expandPath(c.String("socket"))
+		s.listener, s.socketLock, err = listen(s.socketPath)
 		if err != nil {
-			return nil, fmt.Errorf("ensure socket path parent dirs: %v", err)
-		}
-
-		err = os.RemoveAll(socketPath)
-		if err != nil {
-			return nil, fmt.Errorf("unlink socket: %v", err)
+			return nil, err
 		}
+	}
 
-		s.listener, err = net.Listen("unix", socketPath)
-		if err != nil {
-			return nil, fmt.Errorf("listen error: %v", err)
+	if !s.restoreState() {
+		// Start off outside of the working hours, rather than ending a
+		// cycle that never ran.
+		working := s.schedule.working(s.clock.now())
+		for _, t := range s.timers {
+			t.off = !working
 		}
 	}
-
-	s.reset()
+	s.loadDailyProgress()
 
 	return &s, nil
 }
 
-func (s *pomoServer) run() error {
+// run serves clients until ctx is done, then shuts down gracefully.
+func (s *pomoServer) run(ctx context.Context) error {
 	defer s.listener.Close()
-
-	go s.loop()
+	go func() {
+		<-ctx.Done()
+		s.listener.Close()
+	}()
+
+	go s.loop(ctx)
+	go s.notifyLoop(ctx)
+	go s.watchReload(ctx)
+	go s.watchConfig(ctx)
+	if s.metricsListen != "" {
+		go s.serveMetrics(ctx)
+	}
+	if s.waylandIdle {
+		go s.watchWaylandIdle(ctx)
+	}
+	if s.logind {
+		go s.watchLogind(ctx)
+	}
 
 	for {
 		conn, err := s.listener.Accept()
 		if err != nil {
+			if ctx.Err() != nil {
+				s.shutdown()
+				return nil
+			}
 			return fmt.Errorf("accept error: %v", err)
 		}
+		if err := checkPeer(conn); err != nil {
+			log.Warn().Err(err).Msg("rejecting client")
+			conn.Close()
+			continue
+		}
 
 		go s.clientLoop(conn)
 	}
@@ -106,10 +264,9 @@ func (s *pomoServer) removeClient(conn net.Conn) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	for i, c := range s.clients {
-		if c == conn {
-			log.Info().Msgf("disconnecting client %v", conn)
-			s.clients = append(s.clients[:i], s.clients[i+1:]...)
-			delete(s.clientStates, conn)
+		if c.conn == conn {
+			log.Info().Msgf("disconnecting client %p", conn)
+			s.dropClient(i)
 			return
 		}
 	}
@@ -120,7 +277,8 @@ func (s *pomoServer) clientLoop(conn net.Conn) {
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil {
-			if err != io.EOF {
+			// The connection is closed by the server on shutdown.
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
 				log.Error().Err(err).Msg("client read error")
 			}
 			s.removeClient(conn)
@@ -128,83 +286,207 @@ func (s *pomoServer) clientLoop(conn net.Conn) {
 			return
 		}
 
-		command := strings.TrimSpace(line)
-		switch command {
-		case "idle_start":
-			s.idleStart()
-		case "idle_stop":
-			s.idleStop()
-		case "restart":
-			s.restart()
-		case "register":
-			s.register(conn)
-		default:
-			fmt.Printf("unknown command received: %s\n", command)
-		}
+		s.handleLine(conn, strings.TrimSpace(line))
 	}
 }
 
-func (s *pomoServer) loop() {
-	ticker := time.NewTicker(1 * time.Second)
+func (s *pomoServer) loop(ctx context.Context) {
+	ticker, stop := s.clock.ticker(1 * time.Second)
+	defer stop()
 	for {
-		<-ticker.C
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker:
+		}
 		s.mu.Lock()
 		s.sendUpdates()
 		s.mu.Unlock()
 	}
 }
 
-// Needs s.mu locked.
-func (s *pomoServer) reset() {
-	s.workStart = time.Now()
-	s.breakStart = nil
-	s.breakTotal = time.Duration(0)
-	s.clientStates = make(map[net.Conn]string)
-	s.notificationsSent = make(map[uint]bool)
+// shutdown saves the state, tells the widgets the server stopped and
+// removes the socket.
+func (s *pomoServer) shutdown() {
+	s.mu.Lock()
+	s.saveState()
+	clients := s.clients
+	s.clients = nil
+	data, err := json.Marshal(stoppedMessage)
+	if err != nil {
+		log.Error().Err(err).Msg("marshal stopped message")
+	}
+	for _, c := range clients {
+		if err == nil {
+			c.send(append(data, '\n'))
+		}
+		close(c.queue)
+	}
+	s.mu.Unlock()
+
+	// Wait for the queued messages to be written, which is bounded by the
+	// write timeout.
+	for _, c := range clients {
+		<-c.done
+		c.conn.Close()
+	}
+
+	if s.socketPath != "" {
+		if err := os.Remove(s.socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Error().Err(err).Msg("remove socket")
+		}
+		s.socketLock.Close()
+	}
+	log.Info().Msg("server stopped")
 }
 
-func (s *pomoServer) idleStart() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if s.breakStart != nil {
-		log.Warn().Msg("idle_start: break already started")
-		return
+// watchReload reloads the configuration on SIGHUP.
+func (s *pomoServer) watchReload(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := s.reload(); err != nil {
+				log.Error().Err(err).Msg("reload")
+			}
+		}
 	}
-	now := time.Now().Add(time.Duration(-1) * s.idleTimeout)
-	s.breakStart = &now
 }
 
-func (s *pomoServer) idleStop() {
+// reload re-reads the holidays and config files, keeping the current
+// cycles.
+func (s *pomoServer) reload() error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	if s.breakStart == nil {
-		log.Warn().Msg("idle_stop: break not started")
-		return
-	}
-	breakTime := time.Now().Sub(*s.breakStart)
-	if breakTime >= s.breakTime {
-		notify("Welcome back! Start new work cycle.", false)
-		s.reset()
-	} else {
-		s.breakTotal += breakTime
-		s.breakStart = nil
+	err := s.setSchedule(s.workingHours, s.holidaysFile)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := s.loadConfig(); err != nil {
+		return err
 	}
+	log.Info().Msg("reloaded configuration")
+	return nil
 }
 
-func (s *pomoServer) restart() {
+// timer returns the timer with the given name, or the default timer if the
+// name is empty. Needs s.mu locked.
+func (s *pomoServer) timer(name string) (*pomoTimer, error) {
+	if name == "" {
+		name = defaultTimer
+	}
+	for _, t := range s.timers {
+		if t.name == name {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown timer: %s", name)
+}
+
+// withTimer locks s.mu and calls f with the timer named in the arguments.
+func (s *pomoServer) withTimer(args json.RawMessage, f func(t *pomoTimer) error) error {
+	var a timerArgs
+	if len(args) != 0 {
+		if err := parseArgs(args, &a); err != nil {
+			return err
+		}
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.reset()
+	t, err := s.timer(a.Timer)
+	if err != nil {
+		return err
+	}
+	return f(t)
 }
 
-func (s *pomoServer) register(conn net.Conn) {
+// forTimers calls f with the timer named in the arguments, or every timer
+// if none is named, returning the first error. Used for idleness, which
+// applies to all timers.
+func (s *pomoServer) forTimers(args json.RawMessage, f func(t *pomoTimer) error) error {
+	var a timerArgs
+	if len(args) != 0 {
+		if err := parseArgs(args, &a); err != nil {
+			return err
+		}
+	}
+	if a.Timer != "" {
+		return s.withTimer(args, f)
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.clients = append(s.clients, conn)
-	s.sendUpdates()
+	var first error
+	for _, t := range s.timers {
+		if err := f(t); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (s *pomoServer) idleStart() error {
+	// The user has been idle since the idle timeout.
+	return s.startBreak(s.idleTimeout)
+}
+
+// startBreak starts a break that began ago on all timers.
+func (s *pomoServer) startBreak(ago time.Duration) error {
+	return s.forTimers(nil, func(t *pomoTimer) error {
+		return t.startBreak(ago)
+	})
+}
+
+func (s *pomoServer) idleStop() error {
+	return s.forTimers(nil, func(t *pomoTimer) error {
+		return t.idleStop()
+	})
+}
+
+// clientFormat returns the widget format requested in the register
+// arguments, falling back to the server formats.
+func (s *pomoServer) clientFormat(args json.RawMessage) (*widgetFormat, error) {
+	if len(args) == 0 {
+		return s.format, nil
+	}
+	var a registerArgs
+	if err := parseArgs(args, &a); err != nil {
+		return nil, err
+	}
+	if a.Format == "" && a.TooltipFormat == "" && !a.Countdown {
+		return s.format, nil
+	}
+	if a.Format == "" {
+		a.Format = s.textFormat
+		if a.Countdown {
+			a.Format = countdownTextFormat
+		}
+	}
+	if a.TooltipFormat == "" {
+		a.TooltipFormat = s.tooltipFormat
+	}
+	return parseWidgetFormat(a.Format, a.TooltipFormat)
+}
+
+func (s *pomoServer) register(conn net.Conn, args json.RawMessage) error {
+	format, err := s.clientFormat(args)
+	if err != nil {
+		return err
+	}
+	return s.withTimer(args, func(t *pomoTimer) error {
+		s.clients = append(s.clients, newRegisteredClient(conn, format, t))
+		s.sendUpdates()
+		return nil
+	})
 }
 
 func percentage(time time.Duration, max time.Duration) uint {
+	if max <= 0 {
+		return 100
+	}
 	result := uint((100 * time) / max)
 	if result > 100 {
 		result = 100
@@ -212,8 +494,9 @@ func percentage(time time.Duration, max time.Duration) uint {
 	return result
 }
 
-func genMessage(update pomoUpdate) waybar.Message {
-	seconds := uint(update.time.Seconds())
+// formatClock formats a duration as MM:SS, prefixed with the hours if any.
+func formatClock(d time.Duration) string {
+	seconds := uint(d.Seconds())
 	hours := seconds / 3600
 	minutes := (seconds % 3600) / 60
 	seconds = seconds % 60
@@ -222,75 +505,54 @@ func genMessage(update pomoUpdate) waybar.Message {
 	if hours != 0 {
 		text = fmt.Sprintf("%d:%s", hours, text)
 	}
-
-	return waybar.Message{
-		Class:      []string{update.class},
-		Text:       text,
-		Percentage: &update.percentage,
-		Alt:        update.class,
-		Tooltip:    "",
-	}
+	return text
 }
 
 // Requires s.mu locked.
 func (s *pomoServer) sendUpdates() {
-	update := pomoUpdate{}
-	if s.breakStart != nil {
-		update.class = "break"
-		update.time = time.Now().Sub(*s.breakStart)
-		update.percentage = percentage(update.time, s.breakTime)
-	} else {
-		update.time = time.Now().Sub(s.workStart) - s.breakTotal
-		update.class = "work"
-		update.percentage = percentage(update.time, s.workTime)
-		if update.time > s.workTime {
-			update.class = "overtime"
-			s.sendOvertimeNotifications(update.time - s.workTime)
-		}
+	updates := make(map[*pomoTimer]pomoUpdate)
+	statuses := make(map[*pomoTimer]pomoStatus)
+	for _, t := range s.timers {
+		updates[t] = t.tick()
+		statuses[t] = t.statusOf(updates[t])
 	}
 
-	message, err := json.Marshal(genMessage(update))
-	if err != nil {
-		log.Error().Err(err).Msg("marshal json message")
-		return
-	}
-	message = append(message, '\n')
-
-	for _, c := range s.clients {
-		if s.shouldSendUpdate(c, update) {
-			_, err := c.Write(message)
-			if err != nil {
-				log.Error().Err(err).Msg("write to client failed")
-			}
-			s.clientStates[c] = update.class
+	for i := 0; i < len(s.clients); i++ {
+		c := s.clients[i]
+		update, status := updates[c.timer], statuses[c.timer]
+		if !s.shouldSendUpdate(c, update) {
+			continue
+		}
+		message, err := c.format.message(status)
+		if err != nil {
+			log.Error().Err(err).Msg("render message")
+			continue
+		}
+		data, err := json.Marshal(message)
+		if err != nil {
+			log.Error().Err(err).Msg("marshal json message")
+			continue
+		}
+		if !c.send(append(data, '\n')) {
+			log.Warn().Msgf("dropping slow client %p", c.conn)
+			s.dropClient(i)
+			c.conn.Close()
+			i -= 1
+			continue
 		}
+		c.state = update.class
 	}
 }
 
-func (s *pomoServer) shouldSendUpdate(conn net.Conn, update pomoUpdate) bool {
+func (s *pomoServer) shouldSendUpdate(c *registeredClient, update pomoUpdate) bool {
+	if update.class == "off" {
+		// Nothing changes while off.
+		return c.state != update.class
+	}
 	if update.onInterval(s.updateInterval) {
 		return true
 	}
-	if state, ok := s.clientStates[conn]; ok {
-		return state != update.class
-	}
-	return true
-}
-
-func (s *pomoServer) sendOvertimeNotifications(overtime time.Duration) {
-	if overtime < s.overtimeInterval {
-		s.notifyOnce(0, "End of work period. Take a break now", false)
-	} else if overtime < time.Duration(1+s.overtimeNotifications)*s.overtimeInterval {
-		id := uint(overtime / s.overtimeInterval)
-		s.notifyOnce(id, "You are on overtime. Please take a break.", true)
-	}
-}
-
-func (s *pomoServer) notifyOnce(id uint, message string, critical bool) {
-	if _, ok := s.notificationsSent[id]; !ok {
-		notify(message, critical)
-		s.notificationsSent[id] = true
-	}
+	return c.state != update.class
 }
 
 func equal[T comparable](a, b []T) bool {
@@ -305,14 +567,31 @@ func equal[T comparable](a, b []T) bool {
 	return true
 }
 
-func notify(message string, critical bool) {
-	urgency := "normal"
-	if critical {
-		urgency = "critical"
+// Number of notifications that can be queued before they are dropped.
+const notificationQueueSize = 16
+
+// notify queues a notification without blocking.
+func (s *pomoServer) notify(tag string, message string, critical bool) {
+	select {
+	case s.notifications <- notification{tag, message, critical}:
+	default:
+		log.Warn().Msgf("dropping notification: %s", message)
 	}
-	cmd := exec.Command("notify-send", "-a", "pomo", "-u", urgency, message)
-	if err := cmd.Run(); err != nil {
-		log.Error().Err(err).Msg("notify")
+}
+
+func (s *pomoServer) notifyLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-s.notifications:
+			s.mu.Lock()
+			notifier := s.notifier
+			s.mu.Unlock()
+			if err := notifier.notify(n); err != nil {
+				log.Error().Err(err).Msg("notify")
+			}
+		}
 	}
 }
 
@@ -0,0 +1,172 @@
+package pomo
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer returns a server with a single default timer, without a
+// listener, state or history, running on a fake clock.
+func newTestServer(t *testing.T) (*pomoServer, *fakeClock) {
+	t.Helper()
+	clock := newFakeClock()
+	s := &pomoServer{
+		updateInterval: time.Second,
+		idleTimeout:    5 * time.Minute,
+		textFormat:     defaultTextFormat,
+		tooltipFormat:  defaultTooltipFormat,
+		notifier:       noopNotifier{},
+		notifications:  make(chan notification, notificationQueueSize),
+		clock:          clock,
+	}
+	var err error
+	s.format, err = parseWidgetFormat(s.textFormat, s.tooltipFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.timers = append(s.timers, newTimer(s, defaultTimer, timerConfig{
+		workTime:              25 * time.Minute,
+		breakTime:             5 * time.Minute,
+		longBreakTime:         15 * time.Minute,
+		overtimeInterval:      5 * time.Minute,
+		overtimeNotifications: 3,
+		overtimeEscalation:    1,
+	}))
+	return s, clock
+}
+
+// blockingNotifier never returns, like a notify command that waits.
+type blockingNotifier struct {
+	started chan struct{}
+}
+
+func (n blockingNotifier) notify(notification) error {
+	n.started <- struct{}{}
+	select {}
+}
+
+func TestNotifyDoesNotBlock(t *testing.T) {
+	s, _ := newTestServer(t)
+	n := blockingNotifier{make(chan struct{}, 1)}
+	s.notifier = n
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.notifyLoop(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// More than fit in the queue, the rest are dropped.
+		for i := 0; i < 2*notificationQueueSize; i++ {
+			s.notify("", "take a break", false)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("notify blocked")
+	}
+
+	select {
+	case <-n.started:
+	case <-time.After(time.Second):
+		t.Fatal("notification was not sent")
+	}
+	// The notifier is running, the lock is free.
+	s.mu.Lock()
+	s.mu.Unlock()
+}
+
+func TestShouldSendUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    string
+		class    string
+		elapsed  time.Duration
+		interval time.Duration
+		want     bool
+	}{
+		{"new client", "", "work", 61 * time.Second, time.Minute, true},
+		{"between intervals", "work", "work", 61 * time.Second, time.Minute, false},
+		{"on interval", "work", "work", 2 * time.Minute, time.Minute, true},
+		{"phase changed", "work", "overtime", 61 * time.Second, time.Minute, true},
+		{"every second", "work", "work", 61 * time.Second, time.Second, true},
+		{"still off", "off", "off", 0, time.Minute, false},
+		{"turned off", "work", "off", 0, time.Minute, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t)
+			s.updateInterval = tt.interval
+			c := &registeredClient{state: tt.state}
+			update := pomoUpdate{class: tt.class, time: tt.elapsed}
+			if got := s.shouldSendUpdate(c, update); got != tt.want {
+				t.Errorf("shouldSendUpdate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoopSendsUpdatesOnTick(t *testing.T) {
+	s, clock := newTestServer(t)
+	s.updateInterval = time.Minute
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	defer conn.Close()
+	s.mu.Lock()
+	s.clients = append(s.clients, newRegisteredClient(conn, s.format, s.timers[0]))
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.loop(ctx)
+		close(done)
+	}()
+
+	lines := make(chan string)
+	go func() {
+		r := bufio.NewReader(peer)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- line
+		}
+	}()
+	next := func() string {
+		select {
+		case line := <-lines:
+			return line
+		case <-time.After(time.Second):
+			t.Fatal("no update")
+			return ""
+		}
+	}
+
+	// The first tick sends the current phase.
+	clock.tick <- clock.now()
+	if line := next(); !strings.Contains(line, `"class":["work"]`) {
+		t.Errorf("unexpected update %s", line)
+	}
+	// The phase changes on overtime.
+	clock.advance(26 * time.Minute)
+	clock.tick <- clock.now()
+	if line := next(); !strings.Contains(line, `"class":["overtime"]`) {
+		t.Errorf("unexpected update %s", line)
+	}
+
+	cancel()
+	<-done
+	if !clock.stopped {
+		t.Error("ticker not stopped")
+	}
+}
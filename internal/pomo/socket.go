@@ -0,0 +1,122 @@
+package pomo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"syscall"
+)
+
+var errServerRunning = errors.New("server already running")
+
+// listen creates the socket in a directory only accessible by the user. The
+// returned lock file is held while the server runs, so two servers never
+// unlink each other's socket. Close it after removing the socket.
+func listen(socketPath string) (net.Listener, *os.File, error) {
+	dir := path.Dir(socketPath)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, nil, fmt.Errorf("ensure socket path parent dirs: %v", err)
+	}
+	if err := checkOwner(dir); err != nil {
+		return nil, nil, err
+	}
+	// MkdirAll keeps the mode of an existing directory.
+	if err := os.Chmod(dir, 0700); err != nil {
+		return nil, nil, fmt.Errorf("chmod socket dir: %v", err)
+	}
+	if err := checkOwner(socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+
+	lock, err := lockSocket(socketPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	// A server without the lock, like one socket activated by systemd, may
+	// still be listening.
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		lock.Close()
+		return nil, nil, errServerRunning
+	}
+
+	if err := os.RemoveAll(socketPath); err != nil {
+		lock.Close()
+		return nil, nil, fmt.Errorf("unlink socket: %v", err)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		lock.Close()
+		return nil, nil, fmt.Errorf("listen error: %v", err)
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		lock.Close()
+		return nil, nil, fmt.Errorf("chmod socket: %v", err)
+	}
+	return listener, lock, nil
+}
+
+// lockSocket takes an exclusive lock on a file next to the socket. The lock
+// is released when the file is closed, or the process exits.
+func lockSocket(socketPath string) (*os.File, error) {
+	lock, err := os.OpenFile(socketPath+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open socket lock: %v", err)
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		lock.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errServerRunning
+		}
+		return nil, fmt.Errorf("lock socket: %v", err)
+	}
+	return lock, nil
+}
+
+// checkOwner returns an error if the file is owned by another user.
+func checkOwner(filename string) error {
+	info, err := os.Lstat(filename)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is owned by uid %d, not by the current user", filename, stat.Uid)
+	}
+	return nil
+}
+
+// checkPeer returns an error unless the other end of the connection runs
+// as the same user.
+func checkPeer(conn net.Conn) error {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return err
+	}
+	if credErr != nil {
+		return fmt.Errorf("peer credentials: %v", credErr)
+	}
+	if int(cred.Uid) != os.Getuid() {
+		return fmt.Errorf("peer uid %d (pid %d) is not the current user", cred.Uid, cred.Pid)
+	}
+	return nil
+}
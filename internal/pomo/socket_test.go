@@ -0,0 +1,143 @@
+package pomo
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestListen(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "pomo")
+	// An existing directory gets its mode fixed.
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	socketPath := filepath.Join(dir, "pomo.sock")
+	// A stale socket is replaced.
+	if err := os.WriteFile(socketPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, lock, err := listen(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Close()
+	defer listener.Close()
+
+	for _, tt := range []struct {
+		name string
+		mode os.FileMode
+	}{
+		{dir, 0700},
+		{socketPath, 0600},
+	} {
+		info, err := os.Stat(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != tt.mode {
+			t.Errorf("%s has mode %v, want %v", tt.name, info.Mode().Perm(), tt.mode)
+		}
+		if err := checkOwner(tt.name); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestListenWhileRunning(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "pomo", "pomo.sock")
+	listener, lock, err := listen(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Close()
+	defer listener.Close()
+
+	if _, _, err := listen(socketPath); err != errServerRunning {
+		t.Fatalf("listen with the lock held: %v, want %v", err, errServerRunning)
+	}
+
+	// Without the lock, a listening socket is not unlinked either.
+	other := filepath.Join(t.TempDir(), "other.sock")
+	l, err := net.Listen("unix", other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, _, err := listen(other); err != errServerRunning {
+		t.Fatalf("listen on a live socket: %v, want %v", err, errServerRunning)
+	}
+}
+
+func TestCheckOwner(t *testing.T) {
+	dir := t.TempDir()
+	own := filepath.Join(dir, "own")
+	if err := os.WriteFile(own, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkOwner(own); err != nil {
+		t.Errorf("own file: %v", err)
+	}
+	if err := checkOwner(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: %v, want %v", err, os.ErrNotExist)
+	}
+
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner needs root")
+	}
+	other := filepath.Join(dir, "other")
+	if err := os.WriteFile(other, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(other, 65534, 65534); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkOwner(other); err == nil {
+		t.Error("expected an error for a file of another user")
+	}
+}
+
+// socketPair returns both ends of a connected unix socket pair.
+func socketPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make([]net.Conn, 2)
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		// FileConn duplicates the descriptor.
+		conns[i], err = net.FileConn(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return conns[0], conns[1]
+}
+
+func TestCheckPeer(t *testing.T) {
+	conn, peer := socketPair(t)
+	defer peer.Close()
+	defer conn.Close()
+	if _, ok := conn.(*net.UnixConn); !ok {
+		t.Fatalf("expected a unix connection, got %T", conn)
+	}
+	// Both ends belong to this process.
+	if err := checkPeer(conn); err != nil {
+		t.Error(err)
+	}
+
+	// Connections that are not unix sockets have no peer credentials.
+	pipe, pipePeer := net.Pipe()
+	defer pipePeer.Close()
+	defer pipe.Close()
+	if err := checkPeer(pipe); err != nil {
+		t.Error(err)
+	}
+}
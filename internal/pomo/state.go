@@ -0,0 +1,158 @@
+package pomo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type pomoState struct {
+	SavedAt time.Time              `json:"saved_at"`
+	Timers  map[string]*timerState `json:"timers"`
+}
+
+type timerState struct {
+	WorkStart         time.Time     `json:"work_start"`
+	BreakStart        *time.Time    `json:"break_start,omitempty"`
+	BreakTotal        time.Duration `json:"break_total"`
+	NotificationsSent []uint        `json:"notifications_sent"`
+	FinalHookRun      bool          `json:"final_hook_run"`
+	Cycles            uint          `json:"cycles"`
+	PauseStart        *time.Time    `json:"pause_start,omitempty"`
+	PauseTotal        time.Duration `json:"pause_total"`
+	ManualBreak       bool          `json:"manual_break"`
+	WorkExtension     time.Duration `json:"work_extension"`
+	BreakExtension    time.Duration `json:"break_extension"`
+	Task              string        `json:"task,omitempty"`
+	Off               bool          `json:"off"`
+}
+
+func loadState(filename string) (*pomoState, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var state pomoState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parse %s: %v", filename, err)
+	}
+	return &state, nil
+}
+
+func (st *pomoState) save(filename string) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("marshal state: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return fmt.Errorf("ensure state dir: %v", err)
+	}
+
+	// Write to a temporary file first, so a crash halfway never leaves a
+	// truncated state file behind.
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("write state: %v", err)
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return fmt.Errorf("rename state: %v", err)
+	}
+	return nil
+}
+
+// Needs s.mu locked.
+func (s *pomoServer) snapshot() *pomoState {
+	st := pomoState{
+		SavedAt: s.clock.now(),
+		Timers:  make(map[string]*timerState),
+	}
+	for _, t := range s.timers {
+		st.Timers[t.name] = t.snapshot()
+	}
+	return &st
+}
+
+func (t *pomoTimer) snapshot() *timerState {
+	st := timerState{
+		WorkStart:      t.workStart,
+		BreakStart:     t.breakStart,
+		BreakTotal:     t.breakTotal,
+		FinalHookRun:   t.finalHookRun,
+		Cycles:         t.cycles,
+		PauseStart:     t.pauseStart,
+		PauseTotal:     t.pauseTotal,
+		ManualBreak:    t.manualBreak,
+		WorkExtension:  t.workExtension,
+		BreakExtension: t.breakExtension,
+		Task:           t.task,
+		Off:            t.off,
+	}
+	for id := range t.notificationsSent {
+		st.NotificationsSent = append(st.NotificationsSent, id)
+	}
+	return &st
+}
+
+func (t *pomoTimer) restore(st *timerState) {
+	t.workStart = st.WorkStart
+	t.breakStart = st.BreakStart
+	t.breakTotal = st.BreakTotal
+	t.finalHookRun = st.FinalHookRun
+	t.cycles = st.Cycles
+	t.pauseStart = st.PauseStart
+	t.pauseTotal = st.PauseTotal
+	t.manualBreak = st.ManualBreak
+	t.workExtension = st.WorkExtension
+	t.breakExtension = st.BreakExtension
+	t.task = st.Task
+	t.off = st.Off
+	t.notificationsSent = make(map[uint]bool)
+	for _, id := range st.NotificationsSent {
+		t.notificationsSent[id] = true
+	}
+}
+
+// Needs s.mu locked.
+func (s *pomoServer) saveState() {
+	if s.stateFile == "" {
+		return
+	}
+	if err := s.snapshot().save(s.stateFile); err != nil {
+		log.Error().Err(err).Msg("save state")
+	}
+}
+
+// Needs s.mu locked. Returns true if a saved state was restored.
+func (s *pomoServer) restoreState() bool {
+	if s.stateFile == "" {
+		return false
+	}
+
+	st, err := loadState(s.stateFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Error().Err(err).Msg("load state")
+		}
+		return false
+	}
+
+	age := s.clock.now().Sub(st.SavedAt)
+	if s.stateMaxAge != 0 && age > s.stateMaxAge {
+		log.Info().Msgf("discarding saved state of %v ago", age.Round(time.Second))
+		return false
+	}
+
+	for _, t := range s.timers {
+		if ts, ok := st.Timers[t.name]; ok {
+			t.restore(ts)
+		}
+	}
+	log.Info().Msgf("restored state saved %v ago", age.Round(time.Second))
+	return true
+}
@@ -0,0 +1,141 @@
+package pomo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+type statsTotals struct {
+	cycles uint
+	// Cycles cut short by a restart, the end of the working hours or a
+	// break before the work time was up. Their time is counted, but not
+	// their length.
+	cutShort uint
+	work     time.Duration
+	breaks   time.Duration
+	overtime time.Duration
+	length   time.Duration
+}
+
+func (t *statsTotals) add(record historyRecord) {
+	t.work += record.Work
+	t.breaks += record.Break
+	t.overtime += record.Overtime
+	if !record.completed() {
+		t.cutShort += 1
+		return
+	}
+	t.cycles += 1
+	t.length += record.End.Sub(record.Start)
+}
+
+func (t statsTotals) averageCycle() time.Duration {
+	if t.cycles == 0 {
+		return 0
+	}
+	return t.length / time.Duration(t.cycles)
+}
+
+func (t statsTotals) overtimeRatio() float64 {
+	if t.work == 0 {
+		return 0
+	}
+	return 100 * float64(t.overtime) / float64(t.work)
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	hours := d / time.Hour
+	minutes := (d % time.Hour) / time.Minute
+	if hours != 0 {
+		return fmt.Sprintf("%dh%02dm", hours, minutes)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+func weekKey(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+func dayKey(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// groupTotals sums the records per key, returning the most recent limit
+// keys in chronological order.
+func groupTotals(records []historyRecord, key func(time.Time) string, limit int) ([]string, map[string]*statsTotals) {
+	totals := make(map[string]*statsTotals)
+	keys := make([]string, 0)
+	for _, record := range records {
+		k := key(record.Start.Local())
+		t, ok := totals[k]
+		if !ok {
+			t = &statsTotals{}
+			totals[k] = t
+			keys = append(keys, k)
+		}
+		t.add(record)
+	}
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[len(keys)-limit:]
+	}
+	return keys, totals
+}
+
+func printTotals(w *tabwriter.Writer, title string, keys []string, totals map[string]*statsTotals) {
+	fmt.Fprintf(w, "%s\tcycles\tcut short\twork\tbreak\tovertime\tavg cycle\tovertime %%\n", title)
+	for _, k := range keys {
+		t := totals[k]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t%.0f%%\n", k, t.cycles, t.cutShort,
+			formatDuration(t.work), formatDuration(t.breaks),
+			formatDuration(t.overtime), formatDuration(t.averageCycle()),
+			t.overtimeRatio())
+	}
+}
+
+func statsCommand(c *cli.Context) error {
+	filename := expandPath(c.Path("history-file"))
+	all, err := readHistory(filename)
+	// Without a history yet, print zero totals.
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read history: %v", err)
+	}
+
+	timer := c.String("timer")
+	if timer == "" {
+		timer = defaultTimer
+	}
+	records := make([]historyRecord, 0, len(all))
+	for _, record := range all {
+		if record.timerName() == timer {
+			records = append(records, record)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	keys, totals := groupTotals(records, dayKey, c.Int("days"))
+	printTotals(w, "day", keys, totals)
+	fmt.Fprintln(w)
+
+	keys, totals = groupTotals(records, weekKey, c.Int("weeks"))
+	printTotals(w, "week", keys, totals)
+	fmt.Fprintln(w)
+
+	var total statsTotals
+	for _, record := range records {
+		total.add(record)
+	}
+	fmt.Fprintf(w, "total\t%d cycles, %d cut short, average cycle %s, overtime %.0f%%\n",
+		total.cycles, total.cutShort, formatDuration(total.averageCycle()), total.overtimeRatio())
+
+	return w.Flush()
+}
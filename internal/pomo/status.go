@@ -0,0 +1,120 @@
+package pomo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+type pomoStatus struct {
+	Timer      string        `json:"timer"`
+	Phase      string        `json:"phase"`
+	Paused     bool          `json:"paused"`
+	Elapsed    time.Duration `json:"elapsed"`
+	Remaining  time.Duration `json:"remaining"`
+	Overtime   time.Duration `json:"overtime"`
+	Percentage uint          `json:"percentage"`
+	// Time at which the work period ends, zero when not working.
+	NextBreak time.Time `json:"next_break"`
+	// Completed cycles, and the index of the current cycle in the set of
+	// cycles before a long break.
+	Cycles                uint          `json:"cycles"`
+	Cycle                 uint          `json:"cycle"`
+	CyclesBeforeLongBreak uint          `json:"cycles_before_long_break"`
+	WorkTime              time.Duration `json:"work_time"`
+	BreakTime             time.Duration `json:"break_time"`
+	Task                  string        `json:"task"`
+	// Cycles completed since local midnight, and the goal for the day.
+	Today       uint `json:"today"`
+	DailyGoal   uint `json:"daily_goal"`
+	GoalReached bool `json:"goal_reached"`
+}
+
+var templateFuncs = template.FuncMap{
+	"clock": formatClock,
+}
+
+func (t *pomoTimer) statusOf(update pomoUpdate) pomoStatus {
+	status := pomoStatus{
+		Timer:                 t.name,
+		Phase:                 update.class,
+		Paused:                t.pauseStart != nil,
+		Elapsed:               update.time,
+		Percentage:            update.percentage,
+		Cycles:                t.cycles,
+		Cycle:                 t.cycles + 1,
+		CyclesBeforeLongBreak: t.cyclesBeforeLongBreak,
+		WorkTime:              t.workTime,
+		BreakTime:             t.currentBreakTime(),
+		Task:                  t.task,
+		Today:                 t.today(),
+		DailyGoal:             t.dailyGoal,
+		GoalReached:           t.goalReached(),
+	}
+	if t.cyclesBeforeLongBreak != 0 {
+		status.Cycle = t.cycles%t.cyclesBeforeLongBreak + 1
+	}
+
+	limit := t.workLimit()
+	if t.breakStart != nil {
+		limit = t.breakLimit()
+	}
+	if update.class == "off" {
+		return status
+	}
+	if update.time < limit {
+		status.Remaining = limit - update.time
+	} else if t.breakStart == nil {
+		status.Overtime = update.time - limit
+	}
+	if update.class == "work" {
+		status.NextBreak = t.s.clock.now().Add(status.Remaining)
+	}
+	return status
+}
+
+func (st pomoStatus) text() string {
+	return fmt.Sprintf("timer: %s\nphase: %s\nelapsed: %s\nremaining: %s\ncycles: %d\ntoday: %d\nwork time: %v\nbreak time: %v\ntask: %s\n",
+		st.Timer, st.Phase, formatClock(st.Elapsed), formatClock(st.Remaining),
+		st.Cycles, st.Today, st.WorkTime, st.BreakTime, st.Task)
+}
+
+func statusCommand(c *cli.Context) error {
+	client, err := newClient(c)
+	if err != nil {
+		return err
+	}
+	data, err := client.request("status", timerArgs{c.String("timer")})
+	client.close()
+	if err != nil {
+		return err
+	}
+
+	var status pomoStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return fmt.Errorf("parse status: %v", err)
+	}
+
+	switch c.String("format") {
+	case "text":
+		fmt.Print(status.text())
+	case "json":
+		os.Stdout.Write(append(data, '\n'))
+	case "template":
+		tmpl, err := template.New("status").Funcs(templateFuncs).Parse(c.String("template"))
+		if err != nil {
+			return fmt.Errorf("parse template: %v", err)
+		}
+		if err := tmpl.Execute(os.Stdout, status); err != nil {
+			return fmt.Errorf("execute template: %v", err)
+		}
+		fmt.Println()
+	default:
+		return fmt.Errorf("unknown format: %s", c.String("format"))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package pomo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+type taskArgs struct {
+	timerArgs
+	// Label of the task, empty to clear it.
+	Task string `json:"task"`
+}
+
+// setTask labels the current and following cycles with what is being
+// worked on.
+func (t *pomoTimer) setTask(task string) {
+	t.task = strings.TrimSpace(task)
+	t.s.saveState()
+}
+
+// recentTasks returns the distinct task labels in the history, most recent
+// first.
+func recentTasks(records []historyRecord) []string {
+	seen := make(map[string]bool)
+	tasks := make([]string, 0)
+	for i := len(records) - 1; i >= 0; i-- {
+		task := records[i].Task
+		if task == "" || seen[task] {
+			continue
+		}
+		seen[task] = true
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+func taskCommand(c *cli.Context) error {
+	if c.Bool("list") {
+		records, err := readHistory(expandPath(c.Path("history-file")))
+		// Without a history yet, there are no tasks to list.
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("read history: %v", err)
+		}
+		for _, task := range recentTasks(records) {
+			fmt.Println(task)
+		}
+		return nil
+	}
+
+	task := strings.Join(c.Args().Slice(), " ")
+	return sendCommand(c, "task", taskArgs{timerArgs{c.String("timer")}, task})
+}
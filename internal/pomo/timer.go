@@ -0,0 +1,417 @@
+package pomo
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Name of the timer configured by the main flags, used when a command does
+// not name a timer.
+const defaultTimer = "pomo"
+
+// errOff is returned by the timer commands outside the working hours.
+var errOff = errors.New("outside working hours")
+
+type timerConfig struct {
+	workTime              time.Duration
+	breakTime             time.Duration
+	longBreakTime         time.Duration
+	cyclesBeforeLongBreak uint
+	overtimeInterval      time.Duration
+	overtimeNotifications uint
+	// Escalation of the overtime notifications: the factor applied to the
+	// interval after each one, whether to keep sending them beyond
+	// overtimeNotifications, the message per stage and the stage at which
+	// the overtime-final hook runs.
+	overtimeEscalation float64
+	overtimeForever    bool
+	overtimeMessages   []string
+	overtimeFinalStage uint
+	dailyGoal          uint
+}
+
+// parseTimerConfig parses a "name:work:break[:long-break:cycles]" timer
+// definition. Settings that are left out are taken from base.
+func parseTimerConfig(value string, base timerConfig) (string, timerConfig, error) {
+	fields := strings.Split(value, ":")
+	if len(fields) != 3 && len(fields) != 5 {
+		return "", base, fmt.Errorf("invalid timer %s: expected name:work:break[:long-break:cycles]", value)
+	}
+	name := fields[0]
+	if name == "" {
+		return "", base, fmt.Errorf("invalid timer %s: empty name", value)
+	}
+
+	config := base
+	// Long breaks and the daily goal only apply to the main timer, unless
+	// long breaks are configured for the timer.
+	config.cyclesBeforeLongBreak = 0
+	config.dailyGoal = 0
+	durations := []*time.Duration{&config.workTime, &config.breakTime}
+	if len(fields) == 5 {
+		durations = append(durations, &config.longBreakTime)
+	}
+	for i, d := range durations {
+		var err error
+		*d, err = time.ParseDuration(fields[i+1])
+		if err != nil {
+			return "", base, fmt.Errorf("invalid timer %s: %v", value, err)
+		}
+	}
+	if len(fields) == 5 {
+		cycles, err := strconv.ParseUint(fields[4], 10, 32)
+		if err != nil {
+			return "", base, fmt.Errorf("invalid timer %s: %v", value, err)
+		}
+		config.cyclesBeforeLongBreak = uint(cycles)
+	}
+	return name, config, nil
+}
+
+// pomoTimer is a single work/break cycle timer. All methods require s.mu
+// to be locked.
+type pomoTimer struct {
+	timerConfig
+	name string
+	s    *pomoServer
+
+	workStart         time.Time
+	breakStart        *time.Time
+	breakTotal        time.Duration
+	notificationsSent map[uint]bool
+	finalHookRun      bool
+	cycles            uint
+	pauseStart        *time.Time
+	pauseTotal        time.Duration
+	manualBreak       bool
+	workExtension     time.Duration
+	breakExtension    time.Duration
+	// Label of what is being worked on, kept across cycles.
+	task string
+	// Cycles completed on completedDay, for the daily goal.
+	completedToday uint
+	completedDay   string
+	// Outside of the working hours.
+	off bool
+
+	// Counters for the metrics, since the server started.
+	idleBreaks uint64
+	restarts   uint64
+
+	// Last phase seen by sendUpdates, and the last one besides paused.
+	phase       string
+	activePhase string
+}
+
+func newTimer(s *pomoServer, name string, config timerConfig) *pomoTimer {
+	t := &pomoTimer{timerConfig: config, name: name, s: s}
+	t.reset()
+	return t
+}
+
+func (t *pomoTimer) reset() {
+	t.workStart = t.s.clock.now()
+	t.breakStart = nil
+	t.breakTotal = time.Duration(0)
+	for _, c := range t.s.clients {
+		if c.timer == t {
+			c.state = ""
+		}
+	}
+	t.notificationsSent = make(map[uint]bool)
+	t.finalHookRun = false
+	t.pauseStart = nil
+	t.pauseTotal = time.Duration(0)
+	t.manualBreak = false
+	t.workExtension = time.Duration(0)
+	t.breakExtension = time.Duration(0)
+}
+
+// startBreak starts a break that began ago, on idleness.
+func (t *pomoTimer) startBreak(ago time.Duration) error {
+	if t.off {
+		return errOff
+	}
+	if t.breakStart != nil {
+		return errors.New("break already started")
+	}
+	if t.pauseStart != nil {
+		return errors.New("timer is paused")
+	}
+	now := t.s.clock.now().Add(time.Duration(-1) * ago)
+	t.breakStart = &now
+	t.idleBreaks += 1
+	t.s.saveState()
+	return nil
+}
+
+func (t *pomoTimer) idleStop() error {
+	if t.breakStart == nil {
+		return errors.New("break not started")
+	}
+	if t.manualBreak || t.pauseStart != nil {
+		// Manual breaks end by themselves, being idle in between does
+		// not matter.
+		return nil
+	}
+	breakTime := t.s.clock.now().Sub(*t.breakStart)
+	if breakTime >= t.breakLimit() {
+		t.notify("", "Welcome back! Start new work cycle.", false)
+		t.finishCycle("idle")
+	} else {
+		t.breakTotal += breakTime
+		t.breakStart = nil
+		t.breakExtension = time.Duration(0)
+	}
+	t.s.saveState()
+	return nil
+}
+
+func (t *pomoTimer) restart() error {
+	if t.off {
+		return errOff
+	}
+	t.finishCycle("restart")
+	t.restarts += 1
+	t.s.saveState()
+	t.runHook("restart", t.phase, t.currentUpdate())
+	return nil
+}
+
+func (t *pomoTimer) pause() error {
+	if t.off {
+		return errOff
+	}
+	if t.pauseStart != nil {
+		return errors.New("already paused")
+	}
+	now := t.s.clock.now()
+	t.pauseStart = &now
+	t.s.saveState()
+	return nil
+}
+
+func (t *pomoTimer) resume() error {
+	if t.pauseStart == nil {
+		return errors.New("not paused")
+	}
+	t.unpause()
+	t.s.saveState()
+	return nil
+}
+
+func (t *pomoTimer) unpause() {
+	if t.pauseStart == nil {
+		return
+	}
+	paused := t.s.clock.now().Sub(*t.pauseStart)
+	if t.breakStart != nil {
+		// The break continues where it was paused, the pause is counted
+		// as neither work nor break.
+		breakStart := t.breakStart.Add(paused)
+		t.breakStart = &breakStart
+	}
+	t.pauseTotal += paused
+	t.pauseStart = nil
+}
+
+func (t *pomoTimer) toggle() error {
+	if t.pauseStart != nil {
+		return t.resume()
+	}
+	return t.pause()
+}
+
+// skip starts a break when working, or a new work cycle when on a break.
+func (t *pomoTimer) skip() error {
+	if t.off {
+		return errOff
+	}
+	t.unpause()
+	if t.breakStart != nil {
+		t.finishCycle("skip")
+	} else {
+		now := t.s.clock.now()
+		t.breakStart = &now
+		t.manualBreak = true
+	}
+	t.s.saveState()
+	return nil
+}
+
+// extend adds time to the current work period or break.
+func (t *pomoTimer) extend(d time.Duration) error {
+	if t.off {
+		return errOff
+	}
+	if d <= 0 {
+		return errors.New("extension must be positive")
+	}
+	if t.breakStart != nil {
+		t.breakExtension += d
+	} else {
+		t.workExtension += d
+	}
+	t.s.saveState()
+	return nil
+}
+
+func (t *pomoTimer) longBreakDue() bool {
+	return t.cyclesBeforeLongBreak != 0 && (t.cycles+1)%t.cyclesBeforeLongBreak == 0
+}
+
+func (t *pomoTimer) currentBreakTime() time.Duration {
+	if t.longBreakDue() {
+		return t.longBreakTime
+	}
+	return t.breakTime
+}
+
+func (t *pomoTimer) workLimit() time.Duration {
+	return t.workTime + t.workExtension
+}
+
+func (t *pomoTimer) breakLimit() time.Duration {
+	return t.currentBreakTime() + t.breakExtension
+}
+
+func (t *pomoTimer) currentUpdate() pomoUpdate {
+	now := t.s.clock.now()
+	if t.pauseStart != nil {
+		now = *t.pauseStart
+	}
+
+	update := pomoUpdate{}
+	if t.off {
+		update.class = "off"
+	} else if t.breakStart != nil {
+		update.class = "break"
+		update.time = now.Sub(*t.breakStart)
+		update.percentage = percentage(update.time, t.breakLimit())
+	} else {
+		update.time = now.Sub(t.workStart) - t.breakTotal - t.pauseTotal
+		update.class = "work"
+		update.percentage = percentage(update.time, t.workLimit())
+		if update.time > t.workLimit() {
+			update.class = "overtime"
+		}
+	}
+	if t.pauseStart != nil {
+		update.class = "paused"
+	}
+	return update
+}
+
+// tick advances the timer: follows the working hours, sends the due
+// notifications, ends finished manual breaks and runs hooks for phase
+// transitions.
+func (t *pomoTimer) tick() pomoUpdate {
+	working := t.s.schedule.working(t.s.clock.now())
+	if !working && !t.off {
+		t.finishCycle("off")
+		t.off = true
+		t.s.saveState()
+	} else if working && t.off {
+		// Start a fresh cycle when the working hours begin.
+		t.off = false
+		t.reset()
+		t.s.saveState()
+	}
+
+	update := t.currentUpdate()
+	if update.class == "overtime" {
+		t.sendOvertimeNotifications(update)
+	}
+	if update.class == "break" && t.manualBreak && update.time >= t.breakLimit() {
+		t.notify("", "Break is over. Start new work cycle.", false)
+		t.finishCycle("break")
+		t.s.saveState()
+		update = t.currentUpdate()
+	}
+	t.detectTransition(update)
+	return update
+}
+
+// Intervals between overtime notifications never shrink below this, or
+// the overtime interval if that is shorter.
+const minOvertimeInterval = time.Minute
+
+// overtimeStage returns the number of overtime intervals that have passed,
+// where each interval is the previous one times the escalation factor.
+func (t *pomoTimer) overtimeStage(overtime time.Duration) uint {
+	if t.overtimeInterval <= 0 {
+		return 0
+	}
+	floor := minOvertimeInterval
+	if t.overtimeInterval < floor {
+		floor = t.overtimeInterval
+	}
+	interval := t.overtimeInterval
+	var stage uint
+	for end := interval; end <= overtime; end += interval {
+		stage += 1
+		interval = time.Duration(float64(interval) * t.overtimeEscalation)
+		if interval < floor {
+			interval = floor
+		}
+	}
+	return stage
+}
+
+// overtimeMessage returns the message of a stage, the last message is used
+// for all later stages.
+func (t *pomoTimer) overtimeMessage(stage uint) string {
+	if len(t.overtimeMessages) == 0 {
+		return "You are on overtime. Please take a break."
+	}
+	if int(stage) > len(t.overtimeMessages) {
+		return t.overtimeMessages[len(t.overtimeMessages)-1]
+	}
+	return t.overtimeMessages[stage-1]
+}
+
+func (t *pomoTimer) sendOvertimeNotifications(update pomoUpdate) {
+	stage := t.overtimeStage(update.time - t.workLimit())
+	if stage == 0 {
+		message := "End of work period. Take a break now"
+		if t.longBreakDue() {
+			message = "End of work period. Take a long break now"
+		}
+		t.notifyOnce(0, message, false)
+	} else if t.overtimeForever || stage <= t.overtimeNotifications {
+		t.notifyOnce(stage, t.overtimeMessage(stage), true)
+	}
+
+	finalStage := t.overtimeFinalStage
+	if finalStage == 0 {
+		finalStage = t.overtimeNotifications + 1
+	}
+	if stage >= finalStage && !t.finalHookRun {
+		t.finalHookRun = true
+		t.s.saveState()
+		t.runHook("overtime-final", t.phase, update)
+	}
+}
+
+func (t *pomoTimer) notifyOnce(id uint, message string, critical bool) {
+	if _, ok := t.notificationsSent[id]; !ok {
+		t.notify("overtime", message, critical)
+		t.notificationsSent[id] = true
+		t.s.saveState()
+	}
+}
+
+// notify sends a notification, prefixed with the timer name unless it is
+// the default timer.
+func (t *pomoTimer) notify(tag string, message string, critical bool) {
+	if t.name != defaultTimer {
+		message = fmt.Sprintf("%s: %s", t.name, message)
+		if tag != "" {
+			tag = t.name + "/" + tag
+		}
+	}
+	t.s.notify(tag, message, critical)
+}
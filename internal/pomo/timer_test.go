@@ -0,0 +1,336 @@
+package pomo
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// drainNotifications returns the queued notifications.
+func drainNotifications(s *pomoServer) []notification {
+	var ns []notification
+	for {
+		select {
+		case n := <-s.notifications:
+			ns = append(ns, n)
+		default:
+			return ns
+		}
+	}
+}
+
+func sentIDs(t *pomoTimer) []uint {
+	ids := make([]uint, 0)
+	for id := range t.notificationsSent {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func TestOvertimeNotifications(t *testing.T) {
+	type step struct {
+		// Work time since the start of the cycle.
+		at       time.Duration
+		class    string
+		sent     []uint
+		critical []bool
+	}
+	// Work time is 25m, notifications every 5m, up to 3 critical ones.
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "sequence",
+			steps: []step{
+				{24 * time.Minute, "work", []uint{}, nil},
+				// Overtime starts after the work time.
+				{25 * time.Minute, "work", []uint{}, nil},
+				{25*time.Minute + time.Second, "overtime", []uint{0}, []bool{false}},
+				{29 * time.Minute, "overtime", []uint{0}, nil},
+				{30 * time.Minute, "overtime", []uint{0, 1}, []bool{true}},
+				{34 * time.Minute, "overtime", []uint{0, 1}, nil},
+				{36 * time.Minute, "overtime", []uint{0, 1, 2}, []bool{true}},
+				{40 * time.Minute, "overtime", []uint{0, 1, 2, 3}, []bool{true}},
+				{45 * time.Minute, "overtime", []uint{0, 1, 2, 3}, nil},
+				{90 * time.Minute, "overtime", []uint{0, 1, 2, 3}, nil},
+			},
+		},
+		{
+			name: "missed ticks",
+			steps: []step{
+				{10 * time.Minute, "work", []uint{}, nil},
+				{37 * time.Minute, "overtime", []uint{2}, []bool{true}},
+				{37 * time.Minute, "overtime", []uint{2}, nil},
+				{41 * time.Minute, "overtime", []uint{2, 3}, []bool{true}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, clock := newTestServer(t)
+			timer := s.timers[0]
+			start := clock.now()
+			for _, step := range tt.steps {
+				clock.advance(start.Add(step.at).Sub(clock.now()))
+				update := timer.tick()
+				if update.class != step.class {
+					t.Errorf("at %v: class %s, want %s", step.at, update.class, step.class)
+				}
+				if ids := sentIDs(timer); !reflect.DeepEqual(ids, step.sent) {
+					t.Errorf("at %v: sent %v, want %v", step.at, ids, step.sent)
+				}
+				var critical []bool
+				for _, n := range drainNotifications(s) {
+					critical = append(critical, n.critical)
+				}
+				if !reflect.DeepEqual(critical, step.critical) {
+					t.Errorf("at %v: notifications %v, want %v", step.at, critical, step.critical)
+				}
+			}
+		})
+	}
+}
+
+func TestIdleStart(t *testing.T) {
+	tests := []struct {
+		name        string
+		idleTimeout time.Duration
+		paused      bool
+		onBreak     bool
+		wantErr     bool
+	}{
+		{name: "back-dated", idleTimeout: 5 * time.Minute},
+		{name: "no timeout", idleTimeout: 0},
+		{name: "paused", idleTimeout: 5 * time.Minute, paused: true, wantErr: true},
+		{name: "on break", idleTimeout: 5 * time.Minute, onBreak: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, clock := newTestServer(t)
+			s.idleTimeout = tt.idleTimeout
+			timer := s.timers[0]
+			clock.advance(20 * time.Minute)
+			if tt.paused {
+				timer.pause()
+			}
+			if tt.onBreak {
+				if err := timer.skip(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			breakStart := timer.breakStart
+
+			err := s.idleStart()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("idleStart: %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if timer.breakStart != breakStart {
+					t.Error("break start changed")
+				}
+				return
+			}
+			want := clock.now().Add(-tt.idleTimeout)
+			if timer.breakStart == nil || !timer.breakStart.Equal(want) {
+				t.Errorf("break start %v, want %v", timer.breakStart, want)
+			}
+		})
+	}
+}
+
+func TestIdleStop(t *testing.T) {
+	tests := []struct {
+		name string
+		// Cycles completed before, to make a long break due.
+		cycles                uint
+		cyclesBeforeLongBreak uint
+		idle                  time.Duration
+		// Whether the break ended the cycle.
+		full       bool
+		breakTotal time.Duration
+	}{
+		{name: "short break", idle: 2 * time.Minute, breakTotal: 2 * time.Minute},
+		{name: "exact break", idle: 5 * time.Minute, full: true},
+		{name: "long idle", idle: 20 * time.Minute, full: true},
+		{
+			name:   "short long break",
+			cycles: 1, cyclesBeforeLongBreak: 2,
+			idle: 10 * time.Minute, breakTotal: 10 * time.Minute,
+		},
+		{
+			name:   "full long break",
+			cycles: 1, cyclesBeforeLongBreak: 2,
+			idle: 15 * time.Minute, full: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, clock := newTestServer(t)
+			s.idleTimeout = 0
+			timer := s.timers[0]
+			timer.cycles = tt.cycles
+			timer.cyclesBeforeLongBreak = tt.cyclesBeforeLongBreak
+			workStart := timer.workStart
+
+			clock.advance(30 * time.Minute)
+			if err := s.idleStart(); err != nil {
+				t.Fatal(err)
+			}
+			clock.advance(tt.idle)
+			if err := s.idleStop(); err != nil {
+				t.Fatal(err)
+			}
+
+			if timer.breakStart != nil {
+				t.Error("break not stopped")
+			}
+			wantCycles := tt.cycles
+			wantWorkStart := workStart
+			if tt.full {
+				wantCycles += 1
+				wantWorkStart = clock.now()
+			}
+			if timer.cycles != wantCycles {
+				t.Errorf("cycles %d, want %d", timer.cycles, wantCycles)
+			}
+			if !timer.workStart.Equal(wantWorkStart) {
+				t.Errorf("work start %v, want %v", timer.workStart, wantWorkStart)
+			}
+			if timer.breakTotal != tt.breakTotal {
+				t.Errorf("break total %v, want %v", timer.breakTotal, tt.breakTotal)
+			}
+		})
+	}
+}
+
+func TestIdleStopWithoutBreak(t *testing.T) {
+	s, _ := newTestServer(t)
+	if err := s.idleStop(); err == nil {
+		t.Error("expected an error without a break")
+	}
+}
+
+func TestCommandsOutsideWorkingHours(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  func(t *pomoTimer) error
+	}{
+		{"restart", func(t *pomoTimer) error { return t.restart() }},
+		{"skip", func(t *pomoTimer) error { return t.skip() }},
+		{"pause", func(t *pomoTimer) error { return t.pause() }},
+		{"toggle", func(t *pomoTimer) error { return t.toggle() }},
+		{"extend", func(t *pomoTimer) error { return t.extend(time.Minute) }},
+		{"start break", func(t *pomoTimer) error { return t.startBreak(0) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t)
+			timer := s.timers[0]
+			timer.off = true
+			workStart := timer.workStart
+
+			if err := tt.cmd(timer); err != errOff {
+				t.Fatalf("got %v, want %v", err, errOff)
+			}
+			if timer.breakStart != nil || timer.pauseStart != nil ||
+				timer.workExtension != 0 || timer.restarts != 0 ||
+				!timer.workStart.Equal(workStart) {
+				t.Error("timer changed")
+			}
+		})
+	}
+}
+
+func TestFinishCycleWhilePaused(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		cmd     func(t *pomoTimer) error
+	}
+	pause := func(t *pomoTimer) error { return t.pause() }
+	resume := func(t *pomoTimer) error { return t.resume() }
+	skip := func(t *pomoTimer) error { return t.skip() }
+	restart := func(t *pomoTimer) error { return t.restart() }
+	off := func(t *pomoTimer) error {
+		t.finishCycle("off")
+		return nil
+	}
+
+	tests := []struct {
+		name     string
+		steps    []step
+		work     time.Duration
+		breaks   time.Duration
+		overtime time.Duration
+	}{
+		{
+			name:  "restart while paused",
+			steps: []step{{10 * time.Minute, pause}, {60 * time.Minute, restart}},
+			work:  10 * time.Minute,
+		},
+		{
+			name:  "off while paused",
+			steps: []step{{30 * time.Minute, pause}, {60 * time.Minute, off}},
+			work:  30 * time.Minute, overtime: 5 * time.Minute,
+		},
+		{
+			name: "skip while paused on a break",
+			steps: []step{
+				{30 * time.Minute, skip}, {5 * time.Minute, pause}, {60 * time.Minute, skip},
+			},
+			work: 30 * time.Minute, breaks: 5 * time.Minute, overtime: 5 * time.Minute,
+		},
+		{
+			name: "restart while paused on a break",
+			steps: []step{
+				{30 * time.Minute, skip}, {2 * time.Minute, pause}, {60 * time.Minute, restart},
+			},
+			work: 30 * time.Minute, breaks: 2 * time.Minute, overtime: 5 * time.Minute,
+		},
+		{
+			name: "resumed break",
+			steps: []step{
+				{30 * time.Minute, skip}, {2 * time.Minute, pause},
+				{60 * time.Minute, resume}, {3 * time.Minute, skip},
+			},
+			work: 30 * time.Minute, breaks: 5 * time.Minute, overtime: 5 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, clock := newTestServer(t)
+			s.historyFile = filepath.Join(t.TempDir(), "history.jsonl")
+			timer := s.timers[0]
+			for _, step := range tt.steps {
+				clock.advance(step.advance)
+				if err := step.cmd(timer); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			records, err := readHistory(s.historyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 1 {
+				t.Fatalf("got %d records, want 1", len(records))
+			}
+			r := records[0]
+			if r.Work != tt.work || r.Break != tt.breaks || r.Overtime != tt.overtime {
+				t.Errorf("work %v, break %v, overtime %v, want %v, %v, %v",
+					r.Work, r.Break, r.Overtime, tt.work, tt.breaks, tt.overtime)
+			}
+			if timer.pauseStart != nil {
+				t.Error("still paused after the cycle ended")
+			}
+		})
+	}
+}
@@ -28,7 +28,18 @@ in
     idleTimeout = mkOption {
       type = types.ints.unsigned;
       default = 30;
-      description = "Swayidle timeout in seconds";
+      description = "Idle timeout in seconds";
+    };
+
+    idleDetection = mkOption {
+      type = types.enum [ "swayidle" "wayland" ];
+      default = "swayidle";
+      description = ''
+        How idleness is detected: by swayidle calling pomo, or by the server
+        itself using the ext-idle-notify-v1 wayland protocol. The server
+        reconnects when the compositor restarts, and ends a break started by
+        idleness when the connection is lost.
+      '';
     };
 
     settings = mkOption {
@@ -38,7 +49,11 @@ in
   };
 
   config = mkIf cfg.enable {
-    services.pomo.settings.POMO_IDLE_TIMEOUT = "${toString cfg.idleTimeout}s";
+    services.pomo.settings = {
+      POMO_IDLE_TIMEOUT = "${toString cfg.idleTimeout}s";
+    } // optionalAttrs (cfg.idleDetection == "wayland") {
+      POMO_WAYLAND_IDLE = "true";
+    };
 
     systemd.user.services.pomo = {
       Unit = {
@@ -56,11 +71,15 @@ in
 
     systemd.user.sockets.pomo = {
       Unit.Description = "Socket for pomodoro timer";
-      Socket.ListenStream = "%t/waybar-widgets/pomo.sock";
+      Socket = {
+        ListenStream = "%t/waybar-widgets/pomo.sock";
+        SocketMode = "0600";
+        DirectoryMode = "0700";
+      };
       Install.WantedBy = [ "sockets.target" ];
     };
 
-    services.swayidle.timeouts =
+    services.swayidle.timeouts = mkIf (cfg.idleDetection == "swayidle")
       [
         {
           timeout = cfg.idleTimeout;
@@ -6,7 +6,7 @@ buildGoModule rec {
 
   src = ../..;
 
-  vendorSha256 = "sha256-37PbRnArjdhq2bRCJFJa4xDyuPV8uRvtA9wW6zXTgKc=";
+  vendorSha256 = "sha256-+f+RQa2nymcPVM4KGjMsSN5ONEIQle2EV8PnXjhdXfo=";
 
   subPackages = [ "cmd/waybar-widgets" ];
 
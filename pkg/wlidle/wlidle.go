@@ -0,0 +1,285 @@
+// Package wlidle detects user idleness with the ext-idle-notify-v1 Wayland
+// protocol, or the older org_kde_kwin_idle protocol. It speaks just enough
+// of the Wayland wire protocol to do so, without depending on libwayland.
+package wlidle
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	displayID  = 1
+	registryID = 2
+	callbackID = 3
+	seatID     = 4
+	idleID     = 5
+	timeoutID  = 6
+)
+
+// ErrUnsupported is returned when the compositor has neither idle protocol.
+var ErrUnsupported = errors.New("compositor supports no idle protocol")
+
+// Wayland uses the native byte order, all supported systems are little
+// endian.
+var order = binary.LittleEndian
+
+type global struct {
+	name    uint32
+	version uint32
+}
+
+type conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func socketPath() (string, error) {
+	display := os.Getenv("WAYLAND_DISPLAY")
+	if display == "" {
+		display = "wayland-0"
+	}
+	if filepath.IsAbs(display) {
+		return display, nil
+	}
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		return "", errors.New("XDG_RUNTIME_DIR is not set")
+	}
+	return filepath.Join(runtimeDir, display), nil
+}
+
+// Notifier is a connection to the compositor with an idle notification
+// set up.
+type Notifier struct {
+	c conn
+}
+
+// Dial connects to the compositor and asks it to notify when the user has
+// been idle for timeout.
+func Dial(timeout time.Duration) (*Notifier, error) {
+	path, err := socketPath()
+	if err != nil {
+		return nil, err
+	}
+	nc, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("connect to wayland: %v", err)
+	}
+	n, err := newNotifier(nc, timeout)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return n, nil
+}
+
+func newNotifier(nc net.Conn, timeout time.Duration) (*Notifier, error) {
+	c := conn{nc, bufio.NewReader(nc)}
+
+	globals, err := c.globals()
+	if err != nil {
+		return nil, err
+	}
+
+	seat, ok := globals["wl_seat"]
+	if !ok {
+		return nil, errors.New("compositor has no seat")
+	}
+	if err := c.bind(seat, "wl_seat", 1, seatID); err != nil {
+		return nil, err
+	}
+
+	ms := uint32(timeout.Milliseconds())
+	if idle, ok := globals["ext_idle_notifier_v1"]; ok {
+		if err := c.bind(idle, "ext_idle_notifier_v1", 1, idleID); err != nil {
+			return nil, err
+		}
+		// get_idle_notification(new_id, timeout, seat)
+		err = c.request(idleID, 1, uint32(timeoutID), ms, uint32(seatID))
+	} else if idle, ok := globals["org_kde_kwin_idle"]; ok {
+		if err := c.bind(idle, "org_kde_kwin_idle", 1, idleID); err != nil {
+			return nil, err
+		}
+		// get_idle_timeout(new_id, seat, timeout)
+		err = c.request(idleID, 0, uint32(timeoutID), uint32(seatID), ms)
+	} else {
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Notifier{c}, nil
+}
+
+// Run calls handler with true when the user has been idle for the timeout,
+// and with false when the user is active again. It blocks until the
+// connection fails or is closed.
+func (n *Notifier) Run(handler func(idle bool)) error {
+	for {
+		sender, opcode, body, err := n.c.read()
+		if err != nil {
+			return err
+		}
+		switch sender {
+		case displayID:
+			if err := displayError(opcode, body); err != nil {
+				return err
+			}
+		case timeoutID:
+			// Both protocols have the idle event first and the resumed
+			// event second.
+			switch opcode {
+			case 0:
+				handler(true)
+			case 1:
+				handler(false)
+			}
+		}
+	}
+}
+
+// Close closes the connection, which makes Run return.
+func (n *Notifier) Close() error {
+	return n.c.conn.Close()
+}
+
+// globals lists the globals of the registry, by interface name.
+func (c *conn) globals() (map[string]global, error) {
+	// wl_display.get_registry(new_id) and wl_display.sync(new_id)
+	if err := c.request(displayID, 1, uint32(registryID)); err != nil {
+		return nil, err
+	}
+	if err := c.request(displayID, 0, uint32(callbackID)); err != nil {
+		return nil, err
+	}
+
+	globals := make(map[string]global)
+	for {
+		sender, opcode, body, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		switch sender {
+		case displayID:
+			if err := displayError(opcode, body); err != nil {
+				return nil, err
+			}
+		case callbackID:
+			return globals, nil
+		case registryID:
+			if opcode != 0 || len(body) < 8 {
+				continue
+			}
+			// wl_registry.global(name, interface, version)
+			name := order.Uint32(body)
+			iface, rest, err := parseString(body[4:])
+			if err != nil || len(rest) < 4 {
+				continue
+			}
+			if _, ok := globals[iface]; !ok {
+				globals[iface] = global{name, order.Uint32(rest)}
+			}
+		}
+	}
+}
+
+func (c *conn) bind(g global, iface string, version uint32, id uint32) error {
+	if g.version < version {
+		return fmt.Errorf("%s version %d is too old", iface, g.version)
+	}
+	// wl_registry.bind(name, new_id) with an untyped new_id, which is sent
+	// as interface, version and id.
+	return c.request(registryID, 0, g.name, iface, version, id)
+}
+
+func (c *conn) request(object uint32, opcode uint16, args ...interface{}) error {
+	body := make([]byte, 0, 32)
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case uint32:
+			body = appendUint32(body, v)
+		case string:
+			body = appendString(body, v)
+		default:
+			panic(fmt.Sprintf("unsupported argument type %T", arg))
+		}
+	}
+
+	msg := make([]byte, 8, 8+len(body))
+	order.PutUint32(msg, object)
+	order.PutUint32(msg[4:], uint32(8+len(body))<<16|uint32(opcode))
+	msg = append(msg, body...)
+	_, err := c.conn.Write(msg)
+	return err
+}
+
+func (c *conn) read() (uint32, uint16, []byte, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	sender := order.Uint32(header[:])
+	word := order.Uint32(header[4:])
+	size := word >> 16
+	if size < 8 {
+		return 0, 0, nil, fmt.Errorf("invalid message size %d", size)
+	}
+	body := make([]byte, size-8)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return sender, uint16(word & 0xffff), body, nil
+}
+
+// displayError returns the error of a wl_display.error event.
+func displayError(opcode uint16, body []byte) error {
+	if opcode != 0 {
+		return nil
+	}
+	if len(body) < 8 {
+		return errors.New("wayland protocol error")
+	}
+	object := order.Uint32(body)
+	code := order.Uint32(body[4:])
+	message, _, _ := parseString(body[8:])
+	return fmt.Errorf("wayland error on object %d code %d: %s", object, code, message)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	order.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUint32(b, uint32(len(s)+1))
+	b = append(b, s...)
+	// Terminating NUL and padding to 32 bits.
+	for n := len(s) + 1; ; n++ {
+		b = append(b, 0)
+		if n%4 == 0 {
+			break
+		}
+	}
+	return b
+}
+
+func parseString(b []byte) (string, []byte, error) {
+	if len(b) < 4 {
+		return "", nil, errors.New("short string")
+	}
+	length := int(order.Uint32(b))
+	padded := (length + 3) &^ 3
+	if len(b) < 4+padded || length == 0 {
+		return "", nil, errors.New("short string")
+	}
+	return string(b[4 : 4+length-1]), b[4+padded:], nil
+}
@@ -0,0 +1,238 @@
+package wlidle
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		s    string
+		size int
+	}{
+		// Length, then the string with a NUL padded to 32 bits.
+		{"", 8},
+		{"abc", 8},
+		{"abcd", 12},
+		{"wl_seat", 12},
+		{"wl_output", 16},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			b := appendString(nil, tt.s)
+			if len(b) != tt.size {
+				t.Fatalf("size %d, want %d", len(b), tt.size)
+			}
+			if n := order.Uint32(b); n != uint32(len(tt.s)+1) {
+				t.Errorf("length %d, want %d", n, len(tt.s)+1)
+			}
+			if !bytes.Equal(b[4+len(tt.s):], make([]byte, tt.size-4-len(tt.s))) {
+				t.Errorf("padding not zero: %v", b)
+			}
+
+			s, rest, err := parseString(append(b, 1, 2, 3, 4))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s != tt.s {
+				t.Errorf("parsed %q, want %q", s, tt.s)
+			}
+			if !bytes.Equal(rest, []byte{1, 2, 3, 4}) {
+				t.Errorf("rest %v", rest)
+			}
+		})
+	}
+}
+
+func TestParseStringShort(t *testing.T) {
+	for _, b := range [][]byte{
+		nil,
+		{1, 0},
+		// Length without the padded data.
+		appendString(nil, "wl_seat")[:10],
+		// A null string.
+		{0, 0, 0, 0},
+	} {
+		if _, _, err := parseString(b); err == nil {
+			t.Errorf("expected an error for %v", b)
+		}
+	}
+}
+
+func TestRequestHeader(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	c := conn{client, bufio.NewReader(client)}
+	go c.request(7, 3, uint32(42), "ab")
+
+	s := conn{server, bufio.NewReader(server)}
+	sender, opcode, body, err := s.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sender != 7 || opcode != 3 {
+		t.Errorf("sender %d opcode %d, want 7 and 3", sender, opcode)
+	}
+	want := appendString(appendUint32(nil, 42), "ab")
+	if !bytes.Equal(body, want) {
+		t.Errorf("body %v, want %v", body, want)
+	}
+}
+
+func TestReadInvalidSize(t *testing.T) {
+	header := appendUint32(appendUint32(nil, 1), 4<<16)
+	c := conn{nil, bufio.NewReader(bytes.NewReader(header))}
+	if _, _, _, err := c.read(); err == nil {
+		t.Error("expected an error for a message smaller than its header")
+	}
+}
+
+func TestDisplayError(t *testing.T) {
+	body := appendString(appendUint32(appendUint32(nil, 5), 3), "invalid timeout")
+	err := displayError(0, body)
+	if err == nil || !strings.Contains(err.Error(), "object 5 code 3: invalid timeout") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := displayError(0, body[:4]); err == nil {
+		t.Error("expected an error for a short error event")
+	}
+	// wl_display.delete_id is no error.
+	if err := displayError(1, appendUint32(nil, 3)); err != nil {
+		t.Error(err)
+	}
+}
+
+// fakeCompositor answers the requests of a notifier over a pipe.
+type fakeCompositor struct {
+	t *testing.T
+	c conn
+}
+
+func (f *fakeCompositor) event(sender uint32, opcode uint16, args ...interface{}) {
+	if err := f.c.request(sender, opcode, args...); err != nil {
+		f.t.Errorf("send event: %v", err)
+	}
+}
+
+// expect reads a request and checks it matches.
+func (f *fakeCompositor) expect(object uint32, opcode uint16, args ...interface{}) {
+	sender, op, body, err := f.c.read()
+	if err != nil {
+		f.t.Errorf("read request: %v", err)
+		return
+	}
+	var want []byte
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case uint32:
+			want = appendUint32(want, v)
+		case string:
+			want = appendString(want, v)
+		}
+	}
+	if sender != object || op != opcode || !bytes.Equal(body, want) {
+		f.t.Errorf("got request %d.%d %v, want %d.%d %v", sender, op, body, object, opcode, want)
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		iface   string
+		request func(f *fakeCompositor)
+	}{
+		{
+			name:  "ext-idle-notify",
+			iface: "ext_idle_notifier_v1",
+			request: func(f *fakeCompositor) {
+				// get_idle_notification(new_id, timeout, seat)
+				f.expect(idleID, 1, uint32(timeoutID), uint32(300000), uint32(seatID))
+			},
+		},
+		{
+			name:  "kde idle",
+			iface: "org_kde_kwin_idle",
+			request: func(f *fakeCompositor) {
+				// get_idle_timeout(new_id, seat, timeout)
+				f.expect(idleID, 0, uint32(timeoutID), uint32(seatID), uint32(300000))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			f := &fakeCompositor{t, conn{server, bufio.NewReader(server)}}
+
+			setup := make(chan struct{})
+			go func() {
+				defer close(setup)
+				f.expect(displayID, 1, uint32(registryID))
+				f.expect(displayID, 0, uint32(callbackID))
+				f.event(registryID, 0, uint32(10), "wl_compositor", uint32(4))
+				f.event(registryID, 0, uint32(11), "wl_seat", uint32(7))
+				f.event(registryID, 0, uint32(12), tt.iface, uint32(1))
+				f.event(callbackID, 0, uint32(1))
+				f.expect(registryID, 0, uint32(11), "wl_seat", uint32(1), uint32(seatID))
+				f.expect(registryID, 0, uint32(12), tt.iface, uint32(1), uint32(idleID))
+				tt.request(f)
+			}()
+
+			n, err := newNotifier(client, 5*time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			<-setup
+
+			events := make(chan bool, 4)
+			done := make(chan error)
+			go func() {
+				done <- n.Run(func(idle bool) { events <- idle })
+			}()
+			f.event(timeoutID, 0)
+			f.event(timeoutID, 1)
+			// Events of other objects are ignored.
+			f.event(seatID, 0, uint32(1))
+			f.event(displayID, 0, uint32(timeoutID), uint32(0), "gone")
+
+			if err := <-done; err == nil || !strings.Contains(err.Error(), "gone") {
+				t.Errorf("unexpected error %v", err)
+			}
+			close(events)
+			var got []bool
+			for idle := range events {
+				got = append(got, idle)
+			}
+			if !reflect.DeepEqual(got, []bool{true, false}) {
+				t.Errorf("got events %v, want idle and resumed", got)
+			}
+		})
+	}
+}
+
+func TestNotifierUnsupported(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	f := &fakeCompositor{t, conn{server, bufio.NewReader(server)}}
+	go func() {
+		f.expect(displayID, 1, uint32(registryID))
+		f.expect(displayID, 0, uint32(callbackID))
+		f.event(registryID, 0, uint32(11), "wl_seat", uint32(7))
+		f.event(callbackID, 0, uint32(1))
+		f.expect(registryID, 0, uint32(11), "wl_seat", uint32(1), uint32(seatID))
+	}()
+
+	if _, err := newNotifier(client, time.Minute); err != ErrUnsupported {
+		t.Errorf("got %v, want %v", err, ErrUnsupported)
+	}
+}